@@ -1,12 +1,10 @@
 package config
 
 import (
-	"crypto/ecdsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
-	"os"
 	"time"
+	// embed zone database so that timezone lookups do not depend on the host
+	_ "time/tzdata"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -21,9 +19,19 @@ type config struct {
 	ListenAddr string `env:"LISTEN_ADDR" envDefault:"localhost:10101"`
 
 	JWTPublicKeyPath string `env:"JWT_PUBLIC_KEY_PATH" envDefault:"jwt.pub"`
-	JWTPublicKey     *ecdsa.PublicKey
-	JWTAudience      string `env:"JWT_AUDIENCE" envDefault:"bacchus-snu:reservation"`
-	JWTIssuer        string `env:"JWT_ISSUER" envDefault:"bacchus-snu:id"`
+	// JWK Set file or url of the id service, overrides JWTPublicKeyPath if
+	// not empty
+	JWKSPath string `env:"JWKS_PATH" envDefault:""`
+	// interval at which the keys are reloaded, besides on SIGHUP
+	JWKSReloadInterval time.Duration `env:"JWKS_RELOAD_INTERVAL" envDefault:"5m"`
+	JWTKeys            *KeySet
+	JWTAudience        string `env:"JWT_AUDIENCE" envDefault:"bacchus-snu:reservation"`
+	JWTIssuer          string `env:"JWT_ISSUER" envDefault:"bacchus-snu:id"`
+
+	// role every authenticated user has globally, none if empty
+	DefaultRole string `env:"DEFAULT_ROLE" envDefault:"booker"`
+	// longest lifetime of an api token
+	APITokenMaxLifetime time.Duration `env:"API_TOKEN_MAX_LIFETIME" envDefault:"2160h"`
 
 	// bypasses jwt auth
 	DevMode bool `env:"DEV_MODE" envDefault:"false"`
@@ -35,6 +43,23 @@ type config struct {
 	ScheduleRepeatLimit int `env:"SCHEDULE_REPEAT_LIMIT" envDefault:"20"`
 	// schedule query range limit
 	ScheduleTimeRangeLimit time.Duration `env:"SCHEDULE_TIME_RANGE_LIMIT" envDefault:"180h"`
+	// max page size of paged queries
+	PageSizeLimit int `env:"PAGE_SIZE_LIMIT" envDefault:"100"`
+	// IANA zone used to expand recurrences when request does not specify one
+	DefaultTimezone string `env:"DEFAULT_TIMEZONE" envDefault:"Asia/Seoul"`
+	DefaultLocation *time.Location
+
+	// time a hold is kept before it has to be confirmed
+	HoldTTL time.Duration `env:"HOLD_TTL" envDefault:"5m"`
+	// time a waiter has to confirm a slot offered to them
+	WaitlistHoldTTL time.Duration `env:"WAITLIST_HOLD_TTL" envDefault:"30m"`
+	// interval at which expired holds are released
+	SweepInterval time.Duration `env:"SWEEP_INTERVAL" envDefault:"30s"`
+	// time after the start of a booking in a room requiring check-in until it
+	// is released as a no-show
+	CheckInGracePeriod time.Duration `env:"CHECK_IN_GRACE_PERIOD" envDefault:"15m"`
+	// notifications are posted as json to this url, only logged if empty
+	NotifyWebhookURL string `env:"NOTIFY_WEBHOOK_URL" envDefault:""`
 }
 
 var Config *config
@@ -48,22 +73,17 @@ func Parse() error {
 		return err
 	}
 
-	if !Config.IsTest {
-		keyBytes, err := os.ReadFile(Config.JWTPublicKeyPath)
-		if err != nil {
-			return err
-		}
+	loc, err := time.LoadLocation(Config.DefaultTimezone)
+	if err != nil {
+		return err
+	}
+	Config.DefaultLocation = loc
 
-		block, _ := pem.Decode(keyBytes)
-		pubIface, err := x509.ParsePKIXPublicKey(block.Bytes)
-		if err != nil {
+	Config.JWTKeys = new(KeySet)
+	if !Config.IsTest {
+		if err := LoadJWTKeys(); err != nil {
 			return err
 		}
-		pub, ok := pubIface.(*ecdsa.PublicKey)
-		if !ok || pub == nil {
-			return fmt.Errorf("public key alg is not ecdsa")
-		}
-		Config.JWTPublicKey = pub
 	}
 
 	return nil
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// KeySet holds the public keys tokens are verified with. Keys are replaced as
+// a whole when the set is reloaded, so that the signing key can be rotated
+// without a restart.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []jose.JSONWebKey
+}
+
+// Replace swaps the keys of the set.
+func (s *KeySet) Replace(keys []jose.JSONWebKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// Lookup returns the keys with the key id, or every key if kid is empty. If
+// no key has the key id, the keys without a key id are returned, so that a
+// single PEM encoded key verifies tokens whichever key id they carry.
+func (s *KeySet) Lookup(kid string) []jose.JSONWebKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []jose.JSONWebKey{}
+	for _, key := range s.keys {
+		if kid == "" || key.KeyID == kid {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		for _, key := range s.keys {
+			if key.KeyID == "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// LoadJWTKeys reads the JWK Set from JWKSPath, a file or an http(s) url, or
+// the single PEM encoded key from JWTPublicKeyPath if JWKSPath is empty, into
+// JWTKeys.
+func LoadJWTKeys() error {
+	var keys []jose.JSONWebKey
+	if Config.JWKSPath == "" {
+		pub, err := readPublicKey(Config.JWTPublicKeyPath)
+		if err != nil {
+			return err
+		}
+		keys = []jose.JSONWebKey{{Key: pub}}
+	} else {
+		b, err := readJWKS(Config.JWKSPath)
+		if err != nil {
+			return err
+		}
+		var set jose.JSONWebKeySet
+		if err := json.Unmarshal(b, &set); err != nil {
+			return err
+		}
+		for _, key := range set.Keys {
+			if key.Use != "" && key.Use != "sig" {
+				continue
+			}
+			if !key.Valid() {
+				return fmt.Errorf("invalid key %q in jwks", key.KeyID)
+			}
+			keys = append(keys, key.Public())
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no signing keys in jwks")
+		}
+	}
+	Config.JWTKeys.Replace(keys)
+	return nil
+}
+
+func readJWKS(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return os.ReadFile(path)
+	}
+	resp, err := jwksClient.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func readPublicKey(path string) (*ecdsa.PublicKey, error) {
+	keyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block in %s", path)
+	}
+	pubIface, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := pubIface.(*ecdsa.PublicKey)
+	if !ok || pub == nil {
+		return nil, fmt.Errorf("public key alg is not ecdsa")
+	}
+	return pub, nil
+}
+
+// WatchJWTKeys reloads the keys every JWKSReloadInterval and on SIGHUP until
+// the context is done. Keys which fail to load are logged, and the previous
+// keys are kept.
+func WatchJWTKeys(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if Config.JWKSReloadInterval > 0 {
+		ticker := time.NewTicker(Config.JWKSReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-hup:
+			logrus.Info("reloading jwt keys")
+		}
+		if err := LoadJWTKeys(); err != nil {
+			logrus.WithError(err).Error("failed to reload jwt keys")
+		}
+	}
+}
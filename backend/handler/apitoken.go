@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// prefix of api tokens, which tells them from jwts
+const apiTokenPrefix = "rsv_"
+
+// capabilities of scopes other than admin, which is limited by roles only
+var scopeCapabilities = map[string][]capability{
+	types.APITokenScopeRead: {capabilityView},
+	types.APITokenScopeBook: {capabilityView, capabilityBook},
+}
+
+func isValidScope(scope string) bool {
+	_, ok := scopeCapabilities[scope]
+	return ok || scope == types.APITokenScopeAdmin
+}
+
+// scopeAllows reports whether the token may be used for the capability. JWTs
+// may be used for anything the user can do.
+func scopeAllows(p *JWTPayload, c capability) bool {
+	if p.TokenId == 0 || p.Scope == types.APITokenScopeAdmin {
+		return true
+	}
+	for _, sc := range scopeCapabilities[p.Scope] {
+		if sc == c {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAPIToken verifies an api token and records its use. The payload has no
+// roles or permission, so that only roles granted locally, which can be
+// revoked, apply to the token.
+func parseAPIToken(tokenStr string) (*JWTPayload, bool) {
+	var token *types.APIToken
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		token, err = tx.UseAPIToken(hashToken(tokenStr))
+		return err
+	})
+	if err != nil {
+		logrus.WithError(err).Error("failed to verify api token")
+		return nil, false
+	}
+
+	return &JWTPayload{
+		Issuer:   config.Config.JWTIssuer,
+		Audience: config.Config.JWTAudience,
+		Expire:   token.ExpireTimestamp,
+		UserIdx:  int(token.UserIdx),
+		Username: token.Username,
+		TokenId:  token.Id,
+		Scope:    token.Scope,
+	}, true
+}
+
+func HandleGetAPITokens(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if p.TokenId != 0 {
+		httpError(w, http.StatusUnauthorized, "api tokens cannot manage api tokens")
+		return
+	}
+
+	var resp types.GetAPITokensResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		tokens, err := tx.GetAPITokensOfUser(int64(p.UserIdx))
+		if err != nil {
+			return err
+		}
+		resp.Tokens = tokens
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get api tokens", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+// HandleIssueAPIToken issues an api token of the user. Tokens are only managed
+// with a jwt, and the token itself is only shown in the response.
+func HandleIssueAPIToken(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if p.TokenId != 0 {
+		httpError(w, http.StatusUnauthorized, "api tokens cannot manage api tokens")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.IssueAPITokenReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.Name == "" {
+		httpError(w, http.StatusBadRequest, "name is empty")
+		return
+	}
+	if !isValidScope(req.Scope) {
+		httpError(w, http.StatusBadRequest, "invalid scope")
+		return
+	}
+	if req.ExpiresIn <= 0 || time.Duration(req.ExpiresIn)*time.Second > config.Config.APITokenMaxLifetime {
+		httpError(w, http.StatusBadRequest, "invalid expiry")
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to generate token", err)
+		return
+	}
+	resp := types.IssueAPITokenResp{
+		APIToken: types.APIToken{
+			UserIdx:         int64(p.UserIdx),
+			Username:        p.Username,
+			Name:            req.Name,
+			Scope:           req.Scope,
+			ExpireTimestamp: time.Now().Unix() + req.ExpiresIn,
+		},
+		Token: apiTokenPrefix + hex.EncodeToString(buf),
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.AddAPIToken(&resp.APIToken, hashToken(resp.Token))
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to issue api token", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if p.TokenId != 0 {
+		httpError(w, http.StatusUnauthorized, "api tokens cannot manage api tokens")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.RevokeAPITokenReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.RevokeAPIToken(req.TokenId, int64(p.UserIdx))
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to revoke api token", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
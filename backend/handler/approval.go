@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bacchus-snu/reservation/notify"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// requireReapproval sets an approved group back to pending if the user changes
+// it without being able to override policies in a room requiring approval.
+func requireReapproval(tx *sql.Tx, p *JWTPayload, g *types.ScheduleGroup) error {
+	if g.Status != types.ScheduleGroupStatusApproved {
+		return nil
+	}
+	roomIds, err := tx.GetRoomIdsOfScheduleGroup(g.Id)
+	if err != nil {
+		return err
+	}
+	for _, roomId := range roomIds {
+		room, err := tx.GetRoomById(roomId)
+		if err != nil {
+			return err
+		}
+		if !room.RequiresApproval {
+			continue
+		}
+		if ok, err := can(tx, p, capabilityOverridePolicies, roomId); err != nil {
+			return err
+		} else if !ok {
+			return tx.ResetScheduleGroupApproval(g.Id)
+		}
+	}
+	return nil
+}
+
+func HandleGetPendingScheduleGroups(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	var resp types.GetPendingScheduleGroupsResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := canAnywhere(tx, p, capabilityManageBookings); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		groups, err := tx.GetPendingScheduleGroups()
+		if err != nil {
+			return err
+		}
+		// managers only see groups in their rooms
+		resp.Groups = make([]*types.ScheduleGroupWithSchedules, 0, len(groups))
+		for _, g := range groups {
+			if ok, err := canInScheduleGroup(tx, p, capabilityManageBookings, g.Id); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			schedules, err := tx.GetSchedulesInGroup(g.Id, 0)
+			if err != nil {
+				return err
+			}
+			resp.Groups = append(resp.Groups, &types.ScheduleGroupWithSchedules{
+				ScheduleGroup: *g,
+				Schedules:     schedules,
+			})
+		}
+		return nil
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get pending schedule groups", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleApproveScheduleGroup(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.ApproveScheduleGroupReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := canInScheduleGroup(tx, p, capabilityManageBookings, req.ScheduleGroupId); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		return tx.ReviewScheduleGroup(req.ScheduleGroupId, types.ScheduleGroupStatusApproved, req.Reason)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to approve schedule group", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+func HandleRejectScheduleGroup(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.RejectScheduleGroupReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.Reason == "" {
+		httpError(w, http.StatusBadRequest, "reason is empty")
+		return
+	}
+
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := canInScheduleGroup(tx, p, capabilityManageBookings, req.ScheduleGroupId); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		freed, err := tx.GetSchedulesInGroup(req.ScheduleGroupId, 0)
+		if err != nil {
+			return err
+		}
+		if err := tx.ReviewScheduleGroup(req.ScheduleGroupId, types.ScheduleGroupStatusRejected, req.Reason); err != nil {
+			return err
+		}
+
+		// slots of the rejected group are offered to the waitlist
+		for _, s := range freed {
+			ranges := []*types.TimeRange{{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp}}
+			n, err := promoteWaiters(tx, s.RoomId, ranges)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, n...)
+		}
+		return nil
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to reject schedule group", err)
+		return
+	}
+	notify.Send(notifications...)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
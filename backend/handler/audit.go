@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestIdHeader carries the id of the request in responses.
+const RequestIdHeader = "X-Request-Id"
+
+type requestIdKey struct{}
+
+// WithRequestId returns the request carrying the id generated for it, which is
+// recorded with its changes in the audit log. Ids sent by clients are never
+// recorded, as anyone could forge them.
+func WithRequestId(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id))
+}
+
+// actorContext returns a context whose transactions are recorded in the audit
+// log as made by the user of the request. p is nil for requests made without
+// a user, such as check-ins from kiosks.
+func actorContext(r *http.Request, p *JWTPayload) context.Context {
+	requestId, _ := r.Context().Value(requestIdKey{}).(string)
+	actor := &types.AuditActor{
+		UserIdx:   -1,
+		RequestId: requestId,
+	}
+	if p != nil {
+		actor.UserIdx = int64(p.UserIdx)
+		actor.Username = p.Username
+	}
+	return sql.WithActor(context.Background(), actor)
+}
+
+func HandleGetAuditLog(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityViewAuditLog) {
+		return
+	}
+
+	req := types.GetAuditLogReq{
+		UserIdx:        -1,
+		EntityId:       -1,
+		StartTimestamp: -1,
+		EndTimestamp:   -1,
+		PageSize:       config.Config.PageSizeLimit,
+	}
+	qs := r.URL.Query()
+	for key, dst := range map[string]*int64{
+		"userIdx":        &req.UserIdx,
+		"entityId":       &req.EntityId,
+		"startTimestamp": &req.StartTimestamp,
+		"endTimestamp":   &req.EndTimestamp,
+	} {
+		if qs.Get(key) == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(qs.Get(key), 10, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		*dst = v
+	}
+	req.Action = qs.Get("action")
+	req.Entity = qs.Get("entity")
+	req.RequestId = qs.Get("requestId")
+	if qs.Get("page") != "" {
+		page, err := strconv.Atoi(qs.Get("page"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.Page = page
+	}
+	if qs.Get("pageSize") != "" {
+		pageSize, err := strconv.Atoi(qs.Get("pageSize"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.PageSize = pageSize
+	}
+
+	if req.Page < 0 {
+		httpError(w, http.StatusBadRequest, "page is less than 0")
+		return
+	}
+	if req.PageSize <= 0 {
+		httpError(w, http.StatusBadRequest, "page size is less than 1")
+		return
+	}
+	if config.Config.PageSizeLimit < req.PageSize {
+		httpError(w, http.StatusBadRequest, "page size is too large")
+		return
+	}
+
+	var resp types.GetAuditLogResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		entries, err := tx.GetAuditLog(&req)
+		if err != nil {
+			return err
+		}
+		resp.Entries = entries
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get audit log", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
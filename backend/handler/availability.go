@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+func HandleGetAvailability(w http.ResponseWriter, r *http.Request) {
+	req := types.GetAvailabilityReq{
+		CategoryId: -1,
+	}
+	qs := r.URL.Query()
+	sts, err := strconv.ParseInt(qs.Get("startTimestamp"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+	ets, err := strconv.ParseInt(qs.Get("endTimestamp"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+	md, err := strconv.ParseInt(qs.Get("minDuration"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+	if qs.Get("categoryId") != "" {
+		cid, err := strconv.ParseInt(qs.Get("categoryId"), 10, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.CategoryId = cid
+	}
+	if qs.Get("minSeats") != "" {
+		ms, err := strconv.Atoi(qs.Get("minSeats"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.MinSeats = ms
+	}
+	req.StartTimestamp = sts
+	req.EndTimestamp = ets
+	req.MinDuration = md
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	if req.EndTimestamp-req.StartTimestamp > int64(config.Config.ScheduleTimeRangeLimit.Seconds()) {
+		httpError(w, http.StatusBadRequest, "time range is too wide")
+		return
+	}
+	if req.MinDuration <= 0 {
+		httpError(w, http.StatusBadRequest, "min duration is less than 1")
+		return
+	}
+
+	var resp types.GetAvailabilityResp
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		rooms, err := tx.GetFreeIntervals(req.StartTimestamp, req.EndTimestamp, req.MinDuration, req.CategoryId, req.MinSeats)
+		if err != nil {
+			return err
+		}
+
+		// slots outside opening hours or in closures cannot be booked either
+		roomIds := make([]int64, 0, len(rooms))
+		for _, room := range rooms {
+			roomIds = append(roomIds, room.Room.Id)
+		}
+		closures, err := tx.GetClosuresOfRooms(roomIds)
+		if err != nil {
+			return err
+		}
+		resp.Rooms = []*types.RoomAvailability{}
+		for _, room := range rooms {
+			hours, err := tx.GetOpeningHours(room.Room.Id)
+			if err != nil {
+				return err
+			}
+			occurrences, err := closureOccurrences(closures[room.Room.Id], req.StartTimestamp, req.EndTimestamp)
+			if err != nil {
+				return err
+			}
+			intervals := []*types.TimeRange{}
+			for _, free := range room.FreeIntervals {
+				for _, tr := range openIntervals(hours, occurrences, free, config.Config.DefaultLocation) {
+					if tr.EndTimestamp-tr.StartTimestamp >= req.MinDuration {
+						intervals = append(intervals, tr)
+					}
+				}
+			}
+			if len(intervals) > 0 {
+				room.FreeIntervals = intervals
+				resp.Rooms = append(resp.Rooms, room)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get availability", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/notify"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// hashToken hashes kiosk and api tokens to be stored. Tokens are random, so
+// they need no salt.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HandleCheckIn records that a schedule is in use, so that it is not released
+// as a no-show. Check-in opens the grace period before the start and closes at
+// the end of the schedule.
+func HandleCheckIn(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.CheckInReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	var p *JWTPayload
+	if req.KioskToken == "" {
+		var validToken bool
+		p, validToken = ParseToken(r)
+		if !validToken {
+			httpError(w, http.StatusUnauthorized, "failed to verify token")
+			return
+		}
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		schedule, err := tx.GetScheduleById(req.ScheduleId)
+		if err != nil {
+			return err
+		}
+		g, err := tx.GetScheduleGroupById(schedule.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			hash, err := tx.GetRoomKioskTokenHash(schedule.RoomId)
+			if err != nil {
+				return err
+			}
+			if hash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(req.KioskToken))) != 1 {
+				return errors.New("invalid kiosk token")
+			}
+		} else if ok, err := canManageScheduleGroup(tx, p, g); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("you are not the owner of schedule")
+		}
+		if g.Status != types.ScheduleGroupStatusApproved {
+			return errors.New("schedule is not approved")
+		}
+
+		now := time.Now().Unix()
+		grace := int64(config.Config.CheckInGracePeriod / time.Second)
+		if now < schedule.StartTimestamp-grace || now >= schedule.EndTimestamp {
+			return errors.New("check-in is not open")
+		}
+		return tx.CheckInSchedule(schedule.Id)
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to check in", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+// HandleIssueKioskToken issues a new kiosk token of the room, revoking the
+// previous one. The token is only shown in the response.
+func HandleIssueKioskToken(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.IssueKioskTokenReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to generate token", err)
+		return
+	}
+	resp := types.IssueKioskTokenResp{Token: hex.EncodeToString(buf)}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, req.RoomId, -1); err != nil {
+			return err
+		}
+		return tx.SetRoomKioskTokenHash(req.RoomId, hashToken(resp.Token))
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to issue kiosk token", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleGetNoShows(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	if !requireCapability(w, p, capabilityManageBookings) {
+		return
+	}
+
+	var resp types.GetNoShowsResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		users, err := tx.GetNoShowCounts()
+		if err != nil {
+			return err
+		}
+		resp.Users = users
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get no-shows", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+// ReleaseNoShows releases schedules which nobody checked in to within the
+// grace period, offers them to waiters and notifies their owners.
+func ReleaseNoShows() error {
+	var notifications []*notify.Notification
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		released, err := tx.ReleaseNoShows(int64(config.Config.CheckInGracePeriod / time.Second))
+		if err != nil {
+			return err
+		}
+		for _, s := range released {
+			g, err := tx.GetScheduleGroupById(s.ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, &notify.Notification{
+				UserIdx: g.UserIdx,
+				Email:   g.Email,
+				Subject: "Your booking was released",
+				Message: fmt.Sprintf("%s was released since nobody checked in.", formatTimeRange(s.StartTimestamp, s.EndTimestamp)),
+			})
+
+			ranges := []*types.TimeRange{{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp}}
+			n, err := promoteWaiters(tx, s.RoomId, ranges)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, n...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	notify.Send(notifications...)
+	return nil
+}
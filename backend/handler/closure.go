@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// closureOccurrences expands closures to their occurrences which overlap
+// [startTimestamp, endTimestamp). Recurring closures may repeat forever, and
+// only occurrences near the time range are expanded.
+func closureOccurrences(closures []*types.Closure, startTimestamp int64, endTimestamp int64) ([]*types.ClosureOccurrence, error) {
+	occurrences := []*types.ClosureOccurrence{}
+	for _, c := range closures {
+		duration := c.EndTimestamp - c.StartTimestamp
+		starts := []int64{c.StartTimestamp}
+		if c.RRule != "" {
+			rule, err := parseOpenRecurrenceRule(c.RRule)
+			if err != nil {
+				return nil, err
+			}
+			loc, err := loadLocation(c.Timezone)
+			if err != nil {
+				return nil, err
+			}
+			// occurrences starting a duration before the range still overlap it
+			expanded, err := rule.between(time.Unix(c.StartTimestamp, 0).In(loc), time.Unix(startTimestamp-duration, 0), time.Unix(endTimestamp, 0))
+			if err != nil {
+				return nil, err
+			}
+			starts = starts[:0]
+			for _, t := range expanded {
+				starts = append(starts, t.Unix())
+			}
+		}
+
+		for _, start := range starts {
+			if start < endTimestamp && startTimestamp < start+duration {
+				occurrences = append(occurrences, &types.ClosureOccurrence{
+					ClosureId:      c.Id,
+					Reason:         c.Reason,
+					StartTimestamp: start,
+					EndTimestamp:   start + duration,
+				})
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+// openingWindows returns the opening hours of the days overlapping
+// [startTimestamp, endTimestamp) as time ranges in order. Windows which meet,
+// such as one closing at midnight and one opening at midnight the next day,
+// are merged into one.
+func openingWindows(hours []*types.OpeningHours, startTimestamp int64, endTimestamp int64, loc *time.Location) []*types.TimeRange {
+	windows := []*types.TimeRange{}
+	y, m, d := time.Unix(startTimestamp, 0).In(loc).Date()
+	for day := time.Date(y, m, d, 0, 0, 0, 0, loc); day.Unix() < endTimestamp; day = day.AddDate(0, 0, 1) {
+		dy, dm, dd := day.Date()
+		for _, h := range hours {
+			if time.Weekday(h.Weekday) != day.Weekday() {
+				continue
+			}
+			windows = append(windows, &types.TimeRange{
+				StartTimestamp: time.Date(dy, dm, dd, 0, 0, h.OpenTime, 0, loc).Unix(),
+				EndTimestamp:   time.Date(dy, dm, dd, 0, 0, h.CloseTime, 0, loc).Unix(),
+			})
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].StartTimestamp < windows[j].StartTimestamp })
+
+	merged := []*types.TimeRange{}
+	for _, w := range windows {
+		if n := len(merged); n > 0 && w.StartTimestamp <= merged[n-1].EndTimestamp {
+			if w.EndTimestamp > merged[n-1].EndTimestamp {
+				merged[n-1].EndTimestamp = w.EndTimestamp
+			}
+			continue
+		}
+		merged = append(merged, w)
+	}
+	return merged
+}
+
+// checkOpeningHours checks that the time range lies within the opening hours,
+// which may run on into the next day. Rooms without opening hours are always
+// open.
+func checkOpeningHours(hours []*types.OpeningHours, startTimestamp int64, endTimestamp int64, loc *time.Location) error {
+	if len(hours) == 0 {
+		return nil
+	}
+	for _, w := range openingWindows(hours, startTimestamp, endTimestamp, loc) {
+		if w.StartTimestamp <= startTimestamp && endTimestamp <= w.EndTimestamp {
+			return nil
+		}
+	}
+	return policyViolation("booking is outside opening hours")
+}
+
+// openIntervals returns the parts of the free time range which lie within
+// opening hours and outside every closure occurrence. Rooms without opening
+// hours are always open.
+func openIntervals(hours []*types.OpeningHours, occurrences []*types.ClosureOccurrence, free *types.TimeRange, loc *time.Location) []*types.TimeRange {
+	open := []*types.TimeRange{free}
+	if len(hours) > 0 {
+		open = open[:0]
+		for _, w := range openingWindows(hours, free.StartTimestamp, free.EndTimestamp, loc) {
+			openTs, closeTs := w.StartTimestamp, w.EndTimestamp
+			if openTs < free.StartTimestamp {
+				openTs = free.StartTimestamp
+			}
+			if closeTs > free.EndTimestamp {
+				closeTs = free.EndTimestamp
+			}
+			if openTs < closeTs {
+				open = append(open, &types.TimeRange{StartTimestamp: openTs, EndTimestamp: closeTs})
+			}
+		}
+	}
+
+	for _, o := range occurrences {
+		remaining := []*types.TimeRange{}
+		for _, tr := range open {
+			if o.EndTimestamp <= tr.StartTimestamp || tr.EndTimestamp <= o.StartTimestamp {
+				remaining = append(remaining, tr)
+				continue
+			}
+			if tr.StartTimestamp < o.StartTimestamp {
+				remaining = append(remaining, &types.TimeRange{StartTimestamp: tr.StartTimestamp, EndTimestamp: o.StartTimestamp})
+			}
+			if o.EndTimestamp < tr.EndTimestamp {
+				remaining = append(remaining, &types.TimeRange{StartTimestamp: o.EndTimestamp, EndTimestamp: tr.EndTimestamp})
+			}
+		}
+		open = remaining
+	}
+	return open
+}
+
+// checkRoomOpen checks that the room is not archived, and the time ranges
+// against opening hours and closures of the room.
+func checkRoomOpen(tx *sql.Tx, roomId int64, ranges []*types.TimeRange) error {
+	room, err := tx.GetRoomById(roomId)
+	if err != nil {
+		return err
+	}
+	if room.Archived {
+		return policyViolation("room is archived")
+	}
+	hours, err := tx.GetOpeningHours(roomId)
+	if err != nil {
+		return err
+	}
+	closures, err := tx.GetClosuresOfRooms([]int64{roomId})
+	if err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		if err := checkOpeningHours(hours, r.StartTimestamp, r.EndTimestamp, config.Config.DefaultLocation); err != nil {
+			return err
+		}
+		occurrences, err := closureOccurrences(closures[roomId], r.StartTimestamp, r.EndTimestamp)
+		if err != nil {
+			return err
+		}
+		if len(occurrences) > 0 {
+			if occurrences[0].Reason != "" {
+				return policyViolation("room is closed: %s", occurrences[0].Reason)
+			}
+			return policyViolation("room is closed")
+		}
+	}
+	return nil
+}
+
+func HandleGetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	rid, err := strconv.ParseInt(qs.Get("roomId"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+
+	var resp types.GetOpeningHoursResp
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		hours, err := tx.GetOpeningHours(rid)
+		if err != nil {
+			return err
+		}
+		resp.OpeningHours = hours
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get opening hours", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleSetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.SetOpeningHoursReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, req.RoomId, -1); err != nil {
+			return err
+		}
+		return tx.SetOpeningHours(req.RoomId, req.OpeningHours)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to set opening hours", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+func HandleGetClosures(w http.ResponseWriter, r *http.Request) {
+	var resp types.GetClosuresResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		closures, err := tx.GetAllClosures()
+		if err != nil {
+			return err
+		}
+		resp.Closures = closures
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get closures", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleAddClosure(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.AddClosureReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	loc, err := loadLocation(req.Timezone)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "invalid timezone", err)
+		return
+	}
+	closure := &types.Closure{
+		RoomId:         req.RoomId,
+		CategoryId:     req.CategoryId,
+		StartTimestamp: req.StartTimestamp,
+		EndTimestamp:   req.EndTimestamp,
+		RRule:          req.RRule,
+		Timezone:       loc.String(),
+		Reason:         req.Reason,
+	}
+	if closure.RRule != "" {
+		if _, err := parseOpenRecurrenceRule(closure.RRule); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid recurrence rule", err)
+			return
+		}
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, closure.RoomId, closure.CategoryId); err != nil {
+			return err
+		}
+		return tx.AddClosure(closure)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to add closure", err)
+		return
+	}
+
+	if b, err := json.Marshal(closure); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleDeleteClosure(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.DeleteClosureReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		closure, err := tx.GetClosureById(req.ClosureId)
+		if err != nil {
+			return err
+		}
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, closure.RoomId, closure.CategoryId); err != nil {
+			return err
+		}
+		return tx.DeleteClosure(req.ClosureId)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to delete closure", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
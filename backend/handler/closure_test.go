@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOpeningHours(t *testing.T) {
+	// 2022-03-01 is a Tuesday
+	day := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) int64 {
+		return day.Add(time.Duration(hour) * time.Hour).Unix()
+	}
+	hours := []*types.OpeningHours{
+		{Weekday: int(time.Tuesday), OpenTime: 9 * 60 * 60, CloseTime: 12 * 60 * 60},
+		{Weekday: int(time.Tuesday), OpenTime: 13 * 60 * 60, CloseTime: 18 * 60 * 60},
+	}
+
+	assert.Nil(t, checkOpeningHours(nil, at(3), at(4), time.UTC))
+	assert.Nil(t, checkOpeningHours(hours, at(9), at(12), time.UTC))
+	assert.Nil(t, checkOpeningHours(hours, at(14), at(18), time.UTC))
+	assert.NotNil(t, checkOpeningHours(hours, at(8), at(10), time.UTC))
+	assert.NotNil(t, checkOpeningHours(hours, at(11), at(14), time.UTC))
+	assert.NotNil(t, checkOpeningHours(hours, at(24+9), at(24+10), time.UTC))
+
+	// bookings may run overnight when the next day opens at midnight
+	hours = []*types.OpeningHours{
+		{Weekday: int(time.Tuesday), OpenTime: 18 * 60 * 60, CloseTime: 24 * 60 * 60},
+		{Weekday: int(time.Wednesday), OpenTime: 0, CloseTime: 24 * 60 * 60},
+		{Weekday: int(time.Thursday), OpenTime: 0, CloseTime: 6 * 60 * 60},
+	}
+	assert.Nil(t, checkOpeningHours(hours, at(22), at(24+2), time.UTC))
+	assert.Nil(t, checkOpeningHours(hours, at(20), at(48+6), time.UTC))
+	assert.NotNil(t, checkOpeningHours(hours, at(17), at(24+2), time.UTC))
+	assert.NotNil(t, checkOpeningHours(hours, at(48+5), at(48+7), time.UTC))
+}
+
+func TestClosureOccurrences(t *testing.T) {
+	start := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+	const daySec = 24 * 60 * 60
+	closures := []*types.Closure{
+		{Id: 1, StartTimestamp: start, EndTimestamp: start + daySec, Reason: "once"},
+		{Id: 2, StartTimestamp: start, EndTimestamp: start + daySec, RRule: "FREQ=WEEKLY;COUNT=3", Timezone: "UTC", Reason: "weekly"},
+	}
+
+	occurrences, err := closureOccurrences(closures, start+daySec*7, start+daySec*7+1)
+	require.Nil(t, err)
+	require.Len(t, occurrences, 1)
+	assert.Equal(t, int64(2), occurrences[0].ClosureId)
+	assert.Equal(t, start+daySec*7, occurrences[0].StartTimestamp)
+
+	occurrences, err = closureOccurrences(closures, start+daySec, start+daySec*7)
+	require.Nil(t, err)
+	assert.Len(t, occurrences, 0)
+
+	occurrences, err = closureOccurrences(closures, start, start+daySec*21)
+	require.Nil(t, err)
+	assert.Len(t, occurrences, 4)
+
+	// closed every sunday, forever
+	sunday := time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC).Unix()
+	closures = []*types.Closure{
+		{Id: 3, StartTimestamp: sunday, EndTimestamp: sunday + daySec, RRule: "FREQ=WEEKLY;BYDAY=SU", Timezone: "UTC", Reason: "sunday"},
+	}
+	later := time.Date(2040, 6, 3, 12, 0, 0, 0, time.UTC).Unix()
+	occurrences, err = closureOccurrences(closures, later, later+60*60)
+	require.Nil(t, err)
+	require.Len(t, occurrences, 1)
+	assert.Equal(t, later-12*60*60, occurrences[0].StartTimestamp)
+
+	occurrences, err = closureOccurrences(closures, later+daySec, later+daySec*6)
+	require.Nil(t, err)
+	assert.Len(t, occurrences, 0)
+}
+
+func TestOpenIntervals(t *testing.T) {
+	// 2022-03-01 is a Tuesday
+	day := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hour int) int64 {
+		return day.Add(time.Duration(hour) * time.Hour).Unix()
+	}
+	hours := []*types.OpeningHours{
+		{Weekday: int(time.Tuesday), OpenTime: 9 * 60 * 60, CloseTime: 18 * 60 * 60},
+		{Weekday: int(time.Wednesday), OpenTime: 9 * 60 * 60, CloseTime: 12 * 60 * 60},
+	}
+	occurrences := []*types.ClosureOccurrence{
+		{StartTimestamp: at(12), EndTimestamp: at(13)},
+	}
+	free := &types.TimeRange{StartTimestamp: at(10), EndTimestamp: at(24 + 10)}
+
+	assert.Equal(t, []*types.TimeRange{free}, openIntervals(nil, nil, free, time.UTC))
+	assert.Equal(t, []*types.TimeRange{
+		{StartTimestamp: at(10), EndTimestamp: at(12)},
+		{StartTimestamp: at(13), EndTimestamp: at(18)},
+		{StartTimestamp: at(24 + 9), EndTimestamp: at(24 + 10)},
+	}, openIntervals(hours, occurrences, free, time.UTC))
+	assert.Equal(t, []*types.TimeRange{
+		{StartTimestamp: at(10), EndTimestamp: at(12)},
+		{StartTimestamp: at(13), EndTimestamp: at(24 + 10)},
+	}, openIntervals(nil, occurrences, free, time.UTC))
+}
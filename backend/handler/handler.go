@@ -5,17 +5,86 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/notify"
 	"github.com/bacchus-snu/reservation/sql"
 	"github.com/bacchus-snu/reservation/types"
 	"github.com/sirupsen/logrus"
 )
 
-const weekSec int64 = 60 * 60 * 24 * 7
+var (
+	errAllOccurrencesConflict  = errors.New("every occurrence conflicts with other schedules")
+	errRoomHasUpcomingBookings = errors.New("room has upcoming bookings")
+)
+
+// occurrenceOf returns schedules of the group which start at the same time as
+// the given schedule, one for each room of the group.
+func occurrenceOf(tx *sql.Tx, schedule *types.Schedule) ([]*types.Schedule, error) {
+	following, err := tx.GetSchedulesInGroup(schedule.ScheduleGroupId, schedule.StartTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	schedules := []*types.Schedule{}
+	for _, s := range following {
+		if s.StartTimestamp == schedule.StartTimestamp {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+// checkNewSchedules checks that the user can book the rooms, and checks
+// schedules about to be booked, keyed by their room, against opening hours and
+// closures of the room, and unless the user can override them, against booking
+// policies and quotas.
+func checkNewSchedules(tx *sql.Tx, p *JWTPayload, rangesOfRoom map[int64][]*types.TimeRange, loc *time.Location) error {
+	quotaRanges, err := checkRoomsOfNewSchedules(tx, p, rangesOfRoom, loc)
+	if err != nil {
+		return err
+	}
+	return checkBookingQuotas(tx, int64(p.UserIdx), quotaRanges, nil, true, time.Now())
+}
+
+// checkRoomsOfNewSchedules is checkNewSchedules without quotas. It returns the
+// schedules which count against the quotas of the user, keyed by their room.
+func checkRoomsOfNewSchedules(tx *sql.Tx, p *JWTPayload, rangesOfRoom map[int64][]*types.TimeRange, loc *time.Location) (map[int64][]*types.TimeRange, error) {
+	now := time.Now()
+	quotaRanges := map[int64][]*types.TimeRange{}
+	for roomId, ranges := range rangesOfRoom {
+		if ok, err := can(tx, p, capabilityBook, roomId); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, errPermissionDenied
+		}
+		override, err := can(tx, p, capabilityOverridePolicies, roomId)
+		if err != nil {
+			return nil, err
+		}
+		if !override {
+			policy, err := tx.GetEffectiveBookingPolicy(roomId)
+			if err != nil {
+				return nil, err
+			}
+			for _, tr := range ranges {
+				if err := checkBookingPolicy(policy, tr.StartTimestamp, tr.EndTimestamp, now, loc); err != nil {
+					return nil, err
+				}
+			}
+			quotaRanges[roomId] = ranges
+		}
+		if err := checkRoomOpen(tx, roomId, ranges); err != nil {
+			return nil, err
+		}
+	}
+	return quotaRanges, nil
+}
 
 func HandleAddSchedule(w http.ResponseWriter, r *http.Request) {
 	var p *JWTPayload
@@ -37,57 +106,245 @@ func HandleAddSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Repeats <= 0 {
-		httpError(w, http.StatusBadRequest, "repeats is less than 1")
+	loc, err := loadLocation(req.Timezone)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "invalid timezone", err)
 		return
 	}
-	if config.Config.ScheduleRepeatLimit < req.Repeats {
-		httpError(w, http.StatusBadRequest, "too many repeats")
-		return
+	dtstart := time.Unix(req.StartTimestamp, 0).In(loc)
+
+	var startTimestamps []int64
+	if req.RRule == "" {
+		if req.Repeats <= 0 {
+			httpError(w, http.StatusBadRequest, "repeats is less than 1")
+			return
+		}
+		if config.Config.ScheduleRepeatLimit < req.Repeats {
+			httpError(w, http.StatusBadRequest, "too many repeats")
+			return
+		}
+		for i := 0; i < req.Repeats; i++ {
+			startTimestamps = append(startTimestamps, dtstart.AddDate(0, 0, 7*i).Unix())
+		}
+	} else {
+		rule, err := parseRecurrenceRule(req.RRule)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid recurrence rule", err)
+			return
+		}
+		starts, err := rule.expand(dtstart, req.ExDates, config.Config.ScheduleRepeatLimit)
+		if errors.Is(err, errTooManyOccurrences) {
+			httpError(w, http.StatusBadRequest, "too many repeats")
+			return
+		} else if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid recurrence rule", err)
+			return
+		}
+		for _, start := range starts {
+			startTimestamps = append(startTimestamps, start.Unix())
+		}
 	}
 	if req.StartTimestamp >= req.EndTimestamp {
 		httpError(w, http.StatusBadRequest, "invalid time range")
 		return
 	}
+	duration := req.EndTimestamp - req.StartTimestamp
 
-	ctx := context.Background()
+	roomIds := req.RoomIds
+	if len(roomIds) == 0 {
+		roomIds = []int64{req.RoomId}
+	}
+	seen := make(map[int64]bool, len(roomIds))
+	for _, roomId := range roomIds {
+		if seen[roomId] {
+			httpError(w, http.StatusBadRequest, "duplicate room id")
+			return
+		}
+		seen[roomId] = true
+	}
+	if len(roomIds) > 1 && req.SkipConflicts {
+		httpError(w, http.StatusBadRequest, "cannot skip conflicts when booking several rooms")
+		return
+	}
+
+	var resp types.AddScheduleResp
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		ranges := make([]*types.TimeRange, 0, len(startTimestamps))
+		for _, startTs := range startTimestamps {
+			ranges = append(ranges, &types.TimeRange{StartTimestamp: startTs, EndTimestamp: startTs + duration})
+		}
+		rangesOfRoom := make(map[int64][]*types.TimeRange, len(roomIds))
+		for _, roomId := range roomIds {
+			rangesOfRoom[roomId] = ranges
+		}
+		quotaRanges, err := checkRoomsOfNewSchedules(tx, p, rangesOfRoom, loc)
+		if err != nil {
+			return err
+		}
+		// occurrences skipped as conflicts do not count against quotas, which
+		// are checked once the others are booked
+		if !req.SkipConflicts {
+			if err := checkBookingQuotas(tx, int64(p.UserIdx), quotaRanges, nil, true, time.Now()); err != nil {
+				return err
+			}
+		}
+		requiresApproval := false
+		for _, roomId := range roomIds {
+			room, err := tx.GetRoomById(roomId)
+			if err != nil {
+				return err
+			}
+			if room.RequiresApproval {
+				override, err := can(tx, p, capabilityOverridePolicies, roomId)
+				if err != nil {
+					return err
+				}
+				requiresApproval = requiresApproval || !override
+			}
+		}
+
 		g := &types.ScheduleGroup{
-			RoomId:      req.RoomId,
+			RoomId:      roomIds[0],
 			UserIdx:     int64(p.UserIdx),
 			Reservee:    req.Reservee,
 			Email:       req.Email,
 			PhoneNumber: req.PhoneNumber,
 			Reason:      req.Reason,
+			Timezone:    loc.String(),
+		}
+		if req.RRule != "" {
+			g.RRule = req.RRule
+			g.ExDates = req.ExDates
+		}
+		if requiresApproval {
+			g.Status = types.ScheduleGroupStatusPending
 		}
 		if err := tx.AddScheduleGroup(g); err != nil {
 			return err
 		}
 
-		for i := 0; i < req.Repeats; i++ {
-			startTs := req.StartTimestamp + (int64(i) * weekSec)
-			endTs := req.EndTimestamp + (int64(i) * weekSec)
+		for _, startTs := range startTimestamps {
+			if !req.SkipConflicts {
+				for _, roomId := range roomIds {
+					s := &types.Schedule{
+						RoomId:          roomId,
+						ScheduleGroupId: g.Id,
+						StartTimestamp:  startTs,
+						EndTimestamp:    startTs + duration,
+						Seats:           req.Seats,
+						Seat:            req.Seat,
+					}
+					if err := tx.AddSchedule(s); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
 			s := &types.Schedule{
-				RoomId:          req.RoomId,
+				RoomId:          roomIds[0],
 				ScheduleGroupId: g.Id,
 				StartTimestamp:  startTs,
-				EndTimestamp:    endTs,
+				EndTimestamp:    startTs + duration,
+				Seats:           req.Seats,
+				Seat:            req.Seat,
 			}
 
-			if err := tx.AddSchedule(s); err != nil {
+			result := &types.OccurrenceResult{
+				StartTimestamp: s.StartTimestamp,
+				EndTimestamp:   s.EndTimestamp,
+			}
+			var conflictErr *sql.ConflictError
+			if err := tx.AddSchedule(s); errors.As(err, &conflictErr) {
+				if len(conflictErr.Schedules) > 0 {
+					result.ConflictScheduleId = conflictErr.Schedules[0].Id
+				}
+			} else if err != nil {
 				return err
+			} else {
+				result.Created = true
+				result.ScheduleId = s.Id
+			}
+			resp.Occurrences = append(resp.Occurrences, result)
+		}
+		resp.ScheduleGroupId = g.Id
+
+		if req.SkipConflicts {
+			created := []int64{}
+			createdRanges := []*types.TimeRange{}
+			skipped := []int64{}
+			for _, result := range resp.Occurrences {
+				if result.Created {
+					created = append(created, result.ScheduleId)
+					createdRanges = append(createdRanges, &types.TimeRange{StartTimestamp: result.StartTimestamp, EndTimestamp: result.EndTimestamp})
+				} else {
+					skipped = append(skipped, result.StartTimestamp)
+				}
+			}
+			if len(created) == 0 {
+				return errAllOccurrencesConflict
+			}
+			if _, ok := quotaRanges[g.RoomId]; ok {
+				quotaRanges[g.RoomId] = createdRanges
+				if err := checkBookingQuotas(tx, int64(p.UserIdx), quotaRanges, created, true, time.Now()); err != nil {
+					return err
+				}
+			}
+			// the stored rule only yields the occurrences which are booked
+			if g.RRule != "" && len(skipped) > 0 {
+				return tx.AddScheduleGroupExDates(g.Id, skipped)
 			}
 		}
 		return nil
 	})
-	if err != nil {
+	var (
+		conflictErr *sql.ConflictError
+		policyErr   *policyViolationError
+	)
+	if errors.Is(err, errAllOccurrencesConflict) {
+		logrus.WithError(err).Info("failed to add schedule")
+		// nothing is booked, but the conflict of each occurrence is reported
+		resp.ScheduleGroupId = 0
+		if b, err := json.Marshal(&resp); err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+			if _, err := w.Write(b); err != nil {
+				logrus.WithError(err).Error("failed to write conflict response")
+			}
+		}
+		return
+	} else if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if errors.As(err, &policyErr) {
+		httpError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	} else if errors.As(err, &conflictErr) {
+		conflictError(w, "schedule conflicts with other schedules", conflictErr)
+		return
+	} else if err != nil {
 		httpError(w, http.StatusBadRequest, "failed to add schedule", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte("ok")); err != nil {
-		logrus.WithError(err).Error("failed to write success response")
+	if !req.SkipConflicts {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
 	}
 }
 
@@ -111,7 +368,8 @@ func HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
 		schedule, err := tx.GetScheduleById(req.ScheduleId)
 		if err != nil {
@@ -121,22 +379,243 @@ func HandleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return err
 		}
-		if scheduleGroup.UserIdx != int64(p.UserIdx) && !isAdmin(p.PermissionIdx) {
+		if ok, err := canManageScheduleGroup(tx, p, scheduleGroup); err != nil {
+			return err
+		} else if !ok {
 			return errors.New("you are not the owner of schedule")
 		}
+		var freed []*types.Schedule
 		if req.DeleteAllInGroup {
+			freed, err = tx.GetSchedulesInGroup(schedule.ScheduleGroupId, 0)
+			if err != nil {
+				return err
+			}
 			if err := tx.DeleteScheduleGroup(schedule.ScheduleGroupId); err != nil {
 				return err
 			}
 		} else {
-			if err := tx.DeleteSchedule(req.ScheduleId); err != nil {
+			// the occurrence is deleted in every room of the group
+			freed, err = occurrenceOf(tx, schedule)
+			if err != nil {
 				return err
 			}
+			for _, s := range freed {
+				if err := tx.DeleteSchedule(s.Id); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, s := range freed {
+			ranges := []*types.TimeRange{{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp}}
+			n, err := promoteWaiters(tx, s.RoomId, ranges)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, n...)
 		}
 		return nil
 	})
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "failed to add schedule", err)
+		httpError(w, http.StatusBadRequest, "failed to delete schedule", err)
+		return
+	}
+	notify.Send(notifications...)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+// splitScheduleGroup keeps the recurrence rule of the group describing its
+// schedules once the schedules from splitAt on are moved to other times. The
+// rule of the group ends before splitAt, and the moved schedules get a new
+// group with a rule of their own, which is returned. If every schedule of the
+// group was moved, only the rule of the group is replaced.
+func splitScheduleGroup(tx *sql.Tx, g *types.ScheduleGroup, splitAt int64, moved []*types.Schedule, loc *time.Location) (*types.ScheduleGroup, error) {
+	movedIds := make([]int64, 0, len(moved))
+	isMoved := make(map[int64]bool, len(moved))
+	starts := []int64{}
+	for _, s := range moved {
+		movedIds = append(movedIds, s.Id)
+		isMoved[s.Id] = true
+		// schedules in several rooms share their start
+		if n := len(starts); n == 0 || starts[n-1] != s.StartTimestamp {
+			starts = append(starts, s.StartTimestamp)
+		}
+	}
+	rrule, exDates := recurrenceOf(g.RRule, starts, loc)
+
+	all, err := tx.GetSchedulesInGroup(g.Id, 0)
+	if err != nil {
+		return nil, err
+	}
+	kept := false
+	for _, s := range all {
+		kept = kept || !isMoved[s.Id]
+	}
+	if !kept {
+		g.RRule, g.ExDates = rrule, exDates
+		return g, tx.SetScheduleGroupRecurrence(g.Id, rrule, exDates)
+	}
+
+	keptExDates := []int64{}
+	for _, exDate := range g.ExDates {
+		if exDate < splitAt {
+			keptExDates = append(keptExDates, exDate)
+		}
+	}
+	if err := tx.SetScheduleGroupRecurrence(g.Id, ruleUntil(g.RRule, time.Unix(splitAt-1, 0)), keptExDates); err != nil {
+		return nil, err
+	}
+	split := &types.ScheduleGroup{
+		RoomId:        g.RoomId,
+		UserIdx:       g.UserIdx,
+		Reservee:      g.Reservee,
+		Email:         g.Email,
+		PhoneNumber:   g.PhoneNumber,
+		Reason:        g.Reason,
+		RRule:         rrule,
+		ExDates:       exDates,
+		Timezone:      g.Timezone,
+		Status:        g.Status,
+		HoldExpiresAt: g.HoldExpiresAt,
+	}
+	if err := tx.AddScheduleGroup(split); err != nil {
+		return nil, err
+	}
+	if err := tx.MoveSchedulesToGroup(movedIds, split.Id); err != nil {
+		return nil, err
+	}
+	return split, nil
+}
+
+func HandleUpdateSchedule(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.UpdateScheduleReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		schedule, err := tx.GetScheduleById(req.ScheduleId)
+		if err != nil {
+			return err
+		}
+		scheduleGroup, err := tx.GetScheduleGroupById(schedule.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if ok, err := canManageScheduleGroup(tx, p, scheduleGroup); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("you are not the owner of schedule")
+		}
+
+		// schedules of every room of the group are moved together
+		var schedules []*types.Schedule
+		if req.UpdateFollowing {
+			schedules, err = tx.GetSchedulesInGroup(schedule.ScheduleGroupId, schedule.StartTimestamp)
+		} else {
+			schedules, err = occurrenceOf(tx, schedule)
+		}
+		if err != nil {
+			return err
+		}
+
+		loc, err := loadLocation(scheduleGroup.Timezone)
+		if err != nil {
+			return err
+		}
+		from := time.Unix(schedule.StartTimestamp, 0)
+		to := time.Unix(req.StartTimestamp, 0)
+		duration := req.EndTimestamp - req.StartTimestamp
+		for _, s := range schedules {
+			startTs := shiftWallClock(time.Unix(s.StartTimestamp, 0), from, to, loc).Unix()
+			s.StartTimestamp = startTs
+			s.EndTimestamp = startTs + duration
+		}
+
+		roomIds := []int64{}
+		rangesOfRoom := map[int64][]*types.TimeRange{}
+		moved := make([]int64, 0, len(schedules))
+		for _, s := range schedules {
+			if _, ok := rangesOfRoom[s.RoomId]; !ok {
+				roomIds = append(roomIds, s.RoomId)
+			}
+			rangesOfRoom[s.RoomId] = append(rangesOfRoom[s.RoomId], &types.TimeRange{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp})
+			moved = append(moved, s.Id)
+		}
+		now := time.Now()
+		quotaRanges := map[int64][]*types.TimeRange{}
+		for _, roomId := range roomIds {
+			ranges := rangesOfRoom[roomId]
+			override, err := can(tx, p, capabilityOverridePolicies, roomId)
+			if err != nil {
+				return err
+			}
+			if !override {
+				policy, err := tx.GetEffectiveBookingPolicy(roomId)
+				if err != nil {
+					return err
+				}
+				for _, tr := range ranges {
+					if err := checkBookingPolicy(policy, tr.StartTimestamp, tr.EndTimestamp, now, loc); err != nil {
+						return err
+					}
+				}
+				quotaRanges[roomId] = ranges
+			}
+			if err := checkRoomOpen(tx, roomId, ranges); err != nil {
+				return err
+			}
+		}
+		if err := checkBookingQuotas(tx, scheduleGroup.UserIdx, quotaRanges, moved, false, now); err != nil {
+			return err
+		}
+		if err := tx.UpdateSchedules(schedules); err != nil {
+			return err
+		}
+		if req.UpdateFollowing && scheduleGroup.RRule != "" && req.StartTimestamp != schedule.StartTimestamp {
+			scheduleGroup, err = splitScheduleGroup(tx, scheduleGroup, schedule.StartTimestamp, schedules, loc)
+			if err != nil {
+				return err
+			}
+		}
+		return requireReapproval(tx, p, scheduleGroup)
+	})
+	var (
+		conflictErr *sql.ConflictError
+		policyErr   *policyViolationError
+	)
+	if errors.As(err, &policyErr) {
+		httpError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	} else if errors.As(err, &conflictErr) {
+		conflictError(w, "schedule conflicts with other schedules", conflictErr)
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update schedule", err)
 		return
 	}
 
@@ -179,6 +658,7 @@ func HandleGetSchedule(w http.ResponseWriter, r *http.Request) {
 
 	var (
 		schedules []*types.Schedule
+		closures  []*types.ClosureOccurrence
 	)
 	ctx := context.Background()
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
@@ -187,7 +667,12 @@ func HandleGetSchedule(w http.ResponseWriter, r *http.Request) {
 			return err
 		}
 		schedules = schedules_
-		return nil
+		roomClosures, err := tx.GetClosuresOfRooms([]int64{req.RoomId})
+		if err != nil {
+			return err
+		}
+		closures, err = closureOccurrences(roomClosures[req.RoomId], req.StartTimestamp, req.EndTimestamp)
+		return err
 	})
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "failed to get schedule", err)
@@ -200,6 +685,120 @@ func HandleGetSchedule(w http.ResponseWriter, r *http.Request) {
 	} else {
 		resp.Schedules = schedules
 	}
+	resp.Closures = closures
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleGetRoomsSchedule(w http.ResponseWriter, r *http.Request) {
+	req := types.GetRoomsScheduleReq{
+		CategoryId: -1,
+	}
+	qs := r.URL.Query()
+	if qs.Get("categoryId") != "" {
+		cid, err := strconv.ParseInt(qs.Get("categoryId"), 10, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.CategoryId = cid
+	} else {
+		for _, v := range strings.Split(qs.Get("roomIds"), ",") {
+			rid, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+				return
+			}
+			req.RoomIds = append(req.RoomIds, rid)
+		}
+	}
+	sts, err := strconv.ParseInt(qs.Get("startTimestamp"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+	ets, err := strconv.ParseInt(qs.Get("endTimestamp"), 10, 64)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+		return
+	}
+	req.StartTimestamp = sts
+	req.EndTimestamp = ets
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	if req.EndTimestamp-req.StartTimestamp > int64(config.Config.ScheduleTimeRangeLimit.Seconds()) {
+		httpError(w, http.StatusBadRequest, "time range is too wide")
+		return
+	}
+
+	var (
+		roomIds   []int64
+		schedules []*types.Schedule
+		closures  map[int64][]*types.Closure
+	)
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		roomIds = req.RoomIds
+		if req.CategoryId != -1 {
+			roomIds_, err := tx.GetRoomIdsInCategory(req.CategoryId)
+			if err != nil {
+				return err
+			}
+			roomIds = roomIds_
+		}
+		schedules_, err := tx.GetSchedulesOfRooms(roomIds, req.StartTimestamp, req.EndTimestamp)
+		if err != nil {
+			return err
+		}
+		schedules = schedules_
+		closures_, err := tx.GetClosuresOfRooms(roomIds)
+		if err != nil {
+			return err
+		}
+		closures = closures_
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get schedule", err)
+		return
+	}
+
+	resp := types.GetRoomsScheduleResp{
+		Rooms: make([]*types.RoomSchedules, 0, len(roomIds)),
+	}
+	byRoom := map[int64]*types.RoomSchedules{}
+	for _, roomId := range roomIds {
+		if _, ok := byRoom[roomId]; ok {
+			continue
+		}
+		roomClosures, err := closureOccurrences(closures[roomId], req.StartTimestamp, req.EndTimestamp)
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to expand closures", err)
+			return
+		}
+		rs := &types.RoomSchedules{
+			RoomId:    roomId,
+			Schedules: make([]*types.Schedule, 0),
+			Closures:  roomClosures,
+		}
+		byRoom[roomId] = rs
+		resp.Rooms = append(resp.Rooms, rs)
+	}
+	for _, schedule := range schedules {
+		rs := byRoom[schedule.RoomId]
+		rs.Schedules = append(rs.Schedules, schedule)
+	}
 
 	if b, err := json.Marshal(&resp); err != nil {
 		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
@@ -238,7 +837,9 @@ func HandleGetScheduleInfo(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return err
 		}
-		if scheduleGroup.UserIdx != int64(p.UserIdx) && !isAdmin(p.PermissionIdx) {
+		if ok, err := canViewScheduleGroup(tx, p, scheduleGroup); err != nil {
+			return err
+		} else if !ok {
 			return errors.New("you are not the owner of schedule")
 		}
 		resp = scheduleGroup
@@ -260,20 +861,88 @@ func HandleGetScheduleInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func HandleUpdateScheduleInfo(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.UpdateScheduleInfoReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		scheduleGroup, err := tx.GetScheduleGroupById(req.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if ok, err := canManageScheduleGroup(tx, p, scheduleGroup); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("you are not the owner of schedule")
+		}
+		scheduleGroup.Reservee = req.Reservee
+		scheduleGroup.Email = req.Email
+		scheduleGroup.PhoneNumber = req.PhoneNumber
+		scheduleGroup.Reason = req.Reason
+		if err := tx.UpdateScheduleGroup(scheduleGroup); err != nil {
+			return err
+		}
+		return requireReapproval(tx, p, scheduleGroup)
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update schedule info", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
 func HandleGetRoomsAndCategories(w http.ResponseWriter, r *http.Request) {
 	var (
 		resp *types.GetRoomsAndCategoriesResp
 	)
+	p, validToken := ParseToken(r)
+
 	ctx := context.Background()
 	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		// archived rooms are only shown to those who manage rooms
+		showArchived := false
+		if validToken {
+			var err error
+			showArchived, err = can(tx, p, capabilityManageRooms, -1)
+			if err != nil {
+				return err
+			}
+		}
 		categories, err := tx.GetAllCategories()
 		if err != nil {
 			return err
 		}
-		rooms, err := tx.GetAllRooms()
+		allRooms, err := tx.GetAllRooms()
 		if err != nil {
 			return err
 		}
+		rooms := []*types.Room{}
+		for _, room := range allRooms {
+			if !room.Archived || showArchived {
+				rooms = append(rooms, room)
+			}
+		}
 
 		resp = &types.GetRoomsAndCategoriesResp{
 			Categories: categories,
@@ -304,8 +973,8 @@ func HandleAddRoom(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusUnauthorized, "failed to verify token")
 		return
 	}
-	if !isAdmin(p.PermissionIdx) {
-		httpError(w, http.StatusUnauthorized, "admin only")
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
 	}
 
 	b, err := io.ReadAll(r.Body)
@@ -320,19 +989,27 @@ func HandleAddRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
 		room := &types.Room{
-			Name:       req.Name,
-			Seats:      req.Seats,
-			CategoryId: req.CategoryId,
+			Name:             req.Name,
+			Seats:            req.Seats,
+			CategoryId:       req.CategoryId,
+			RequiresApproval: req.RequiresApproval,
+			Shared:           req.Shared,
+			RequiresCheckIn:  req.RequiresCheckIn,
+			BufferBefore:     req.BufferBefore,
+			BufferAfter:      req.BufferAfter,
 		}
 		if err := tx.AddRoom(room); err != nil {
 			return err
 		}
 		return nil
 	})
-	if err != nil {
+	if errors.Is(err, sql.ErrDuplicateName) {
+		httpError(w, http.StatusConflict, "room name already exists")
+		return
+	} else if err != nil {
 		httpError(w, http.StatusBadRequest, "failed to add room", err)
 		return
 	}
@@ -350,8 +1027,8 @@ func HandleAddCategory(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusUnauthorized, "failed to verify token")
 		return
 	}
-	if !isAdmin(p.PermissionIdx) {
-		httpError(w, http.StatusUnauthorized, "admin only")
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
 	}
 
 	b, err := io.ReadAll(r.Body)
@@ -366,7 +1043,7 @@ func HandleAddCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
 		category := &types.Category{
 			Name:        req.Name,
@@ -377,7 +1054,10 @@ func HandleAddCategory(w http.ResponseWriter, r *http.Request) {
 		}
 		return nil
 	})
-	if err != nil {
+	if errors.Is(err, sql.ErrDuplicateName) {
+		httpError(w, http.StatusConflict, "category name already exists")
+		return
+	} else if err != nil {
 		httpError(w, http.StatusBadRequest, "failed to add category", err)
 		return
 	}
@@ -388,15 +1068,15 @@ func HandleAddCategory(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func HandleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+func HandleUpdateRoom(w http.ResponseWriter, r *http.Request) {
 	var p *JWTPayload
 	p, validToken := ParseToken(r)
 	if !validToken {
 		httpError(w, http.StatusUnauthorized, "failed to verify token")
 		return
 	}
-	if !isAdmin(p.PermissionIdx) {
-		httpError(w, http.StatusUnauthorized, "admin only")
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
 	}
 
 	b, err := io.ReadAll(r.Body)
@@ -405,30 +1085,273 @@ func HandleDeleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req types.DeleteRoomReq
+	var req types.UpdateRoomReq
 	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
 		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
 		return
 	}
+	if req.Seats != nil && *req.Seats <= 0 {
+		httpError(w, http.StatusBadRequest, "seats must be positive")
+		return
+	}
 
-	ctx := context.Background()
+	var room *types.Room
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		room, err = tx.GetRoomById(req.RoomId)
+		if err != nil {
+			return err
+		}
+		if req.Name != nil {
+			room.Name = *req.Name
+		}
+		if req.Seats != nil {
+			if err := tx.CheckRoomSeats(room.Id, *req.Seats, time.Now().Unix()); err != nil {
+				return err
+			}
+			room.Seats = *req.Seats
+		}
+		if req.CategoryId != nil {
+			room.CategoryId = *req.CategoryId
+		}
+		return tx.UpdateRoom(room)
+	})
+	var conflictErr *sql.ConflictError
+	if errors.Is(err, sql.ErrDuplicateName) {
+		httpError(w, http.StatusConflict, "room name already exists")
+		return
+	} else if errors.As(err, &conflictErr) {
+		conflictError(w, "upcoming bookings do not fit in the seats", conflictErr)
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update room", err)
+		return
+	}
+
+	if b, err := json.Marshal(room); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleUpdateCategory(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.UpdateCategoryReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	var category *types.Category
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
-		if err := tx.DeleteRoom(req.RoomId); err != nil {
+		category, err = tx.GetCategoryById(req.CategoryId)
+		if err != nil {
 			return err
 		}
+		if req.Name != nil {
+			category.Name = *req.Name
+		}
+		if req.Description != nil {
+			category.Description = *req.Description
+		}
+		return tx.UpdateCategory(category)
+	})
+	if errors.Is(err, sql.ErrDuplicateName) {
+		httpError(w, http.StatusConflict, "category name already exists")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update category", err)
+		return
+	}
+
+	if b, err := json.Marshal(category); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.ArchiveRoomReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.SetRoomArchived(req.RoomId, req.Archived); err != nil {
+			return err
+		}
+		if !req.Archived {
+			return nil
+		}
+		// archived rooms cannot be booked, so nobody is promoted from the waitlist
+		room, err := tx.GetRoomById(req.RoomId)
+		if err != nil {
+			return err
+		}
+		entries, err := tx.ExpireWaitlistEntriesOfRoom(req.RoomId)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			notifications = append(notifications, &notify.Notification{
+				UserIdx: entry.UserIdx,
+				Email:   entry.Email,
+				Subject: "A slot you are waiting for cannot be booked",
+				Message: fmt.Sprintf("%s is no longer available, and you are removed from its waitlist for %s.",
+					room.Name, formatTimeRange(entry.StartTimestamp, entry.EndTimestamp)),
+			})
+		}
 		return nil
 	})
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "failed to delete room", err)
+		httpError(w, http.StatusBadRequest, "failed to archive room", err)
 		return
 	}
+	notify.Send(notifications...)
 
 	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(b); err != nil {
+	if _, err := w.Write([]byte("ok")); err != nil {
 		logrus.WithError(err).Error("failed to write success response")
 	}
 }
 
+// HandleDeleteRoom deletes the room with every schedule in it. Rooms with
+// upcoming bookings are only deleted if forced, in which case the reservees
+// are notified. Archiving the room keeps its history instead.
+func HandleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.DeleteRoomReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	resp := types.DeleteRoomResp{Msg: "ok"}
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		room, err := tx.GetRoomById(req.RoomId)
+		if err != nil {
+			return err
+		}
+		groups, err := tx.GetUpcomingScheduleGroupsOfRoom(req.RoomId)
+		if err != nil {
+			return err
+		}
+		resp.AffectedGroups = groups
+		if len(groups) > 0 && !req.Force {
+			return errRoomHasUpcomingBookings
+		}
+		for _, g := range groups {
+			if g.Status == types.ScheduleGroupStatusRejected {
+				continue
+			}
+			msg := fmt.Sprintf("%s is removed, and your upcoming bookings in it are cancelled.", room.Name)
+			roomIds, err := tx.GetRoomIdsOfScheduleGroup(g.Id)
+			if err != nil {
+				return err
+			}
+			if len(roomIds) > 1 {
+				msg += " Your bookings in the other rooms are kept."
+			}
+			notifications = append(notifications, &notify.Notification{
+				UserIdx: g.UserIdx,
+				Email:   g.Email,
+				Subject: "Your booking is cancelled",
+				Message: msg,
+			})
+		}
+		return tx.DeleteRoom(req.RoomId)
+	})
+	if errors.Is(err, errRoomHasUpcomingBookings) {
+		resp.Msg = "room has upcoming bookings"
+		logrus.WithError(err).Info(resp.Msg)
+		if b, err := json.Marshal(&resp); err != nil {
+			httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+			if _, err := w.Write(b); err != nil {
+				logrus.WithError(err).Error("failed to write conflict response")
+			}
+		}
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to delete room", err)
+		return
+	}
+	notify.Send(notifications...)
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
 func HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
 	var p *JWTPayload
 	p, validToken := ParseToken(r)
@@ -436,8 +1359,8 @@ func HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
 		httpError(w, http.StatusUnauthorized, "failed to verify token")
 		return
 	}
-	if !isAdmin(p.PermissionIdx) {
-		httpError(w, http.StatusUnauthorized, "admin only")
+	if !requireCapability(w, p, capabilityManageRooms) {
+		return
 	}
 
 	b, err := io.ReadAll(r.Body)
@@ -452,7 +1375,7 @@ func HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := actorContext(r, p)
 	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
 		if err := tx.DeleteCategory(req.CategoryId); err != nil {
 			return err
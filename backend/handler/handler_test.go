@@ -6,10 +6,16 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	mathrand "math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 
 	"net/http"
 	"net/http/httptest"
@@ -28,6 +34,8 @@ import (
 
 var jwtPrivateKey *ecdsa.PrivateKey
 
+const jwtKeyId = "test-key"
+
 func TestMain(m *testing.M) {
 	initTest()
 	code := m.Run()
@@ -48,7 +56,7 @@ func initTest() {
 	if err != nil {
 		panic(err)
 	}
-	config.Config.JWTPublicKey = &priv.PublicKey
+	config.Config.JWTKeys.Replace([]jose.JSONWebKey{{Key: &priv.PublicKey, KeyID: jwtKeyId}})
 	jwtPrivateKey = priv
 }
 
@@ -74,7 +82,11 @@ func generateTokenWithPayload(payload *handler.JWTPayload) (string, error) {
 	if jwtPrivateKey == nil {
 		panic("private key is not provided")
 	}
-	signingKey := jose.SigningKey{Algorithm: jose.ES256, Key: jwtPrivateKey}
+	return signToken(payload, jose.JSONWebKey{Key: jwtPrivateKey, KeyID: jwtKeyId})
+}
+
+func signToken(payload *handler.JWTPayload, key jose.JSONWebKey) (string, error) {
+	signingKey := jose.SigningKey{Algorithm: jose.ES256, Key: key}
 	signer, err := jose.NewSigner(signingKey, nil)
 	if err != nil {
 		return "", err
@@ -84,6 +96,41 @@ func generateTokenWithPayload(payload *handler.JWTPayload) (string, error) {
 	return builder.CompactSerialize()
 }
 
+func addRoomForTest(t *testing.T, name string) *types.Room {
+	category := &types.Category{
+		Name:        name + " category",
+		Description: name + " category description",
+	}
+	room := &types.Room{
+		Name:  name,
+		Seats: 10,
+	}
+	err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		if err := tx.AddCategory(category); err != nil {
+			return err
+		}
+		room.CategoryId = category.Id
+		return tx.AddRoom(room)
+	})
+	require.Nil(t, err)
+	return room
+}
+
+func doRequest(t *testing.T, f http.HandlerFunc, method string, target string, body interface{}, userIdx int, permissionIdx int) *http.Response {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.Nil(t, err)
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	setJWTToken(t, req, userIdx, "doge", permissionIdx)
+	w := httptest.NewRecorder()
+
+	f(w, req)
+	return w.Result()
+}
+
 func TestJWT(t *testing.T) {
 	{
 		// no authorization header
@@ -167,6 +214,90 @@ func TestJWT(t *testing.T) {
 	}
 }
 
+func TestJWTKeyRotation(t *testing.T) {
+	defer config.Config.JWTKeys.Replace([]jose.JSONWebKey{{Key: &jwtPrivateKey.PublicKey, KeyID: jwtKeyId}})
+	defer func() { config.Config.JWKSPath = "" }()
+
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	payload := &handler.JWTPayload{
+		Issuer:        config.Config.JWTIssuer,
+		Audience:      config.Config.JWTAudience,
+		Expire:        time.Now().Add(time.Second * 100).Unix(),
+		UserIdx:       1,
+		Username:      "foo",
+		PermissionIdx: 1,
+	}
+	oldToken, err := generateTokenWithPayload(payload)
+	require.Nil(t, err)
+	newToken, err := signToken(payload, jose.JSONWebKey{Key: rotated, KeyID: "rotated"})
+	require.Nil(t, err)
+	noKidToken, err := signToken(payload, jose.JSONWebKey{Key: rotated})
+	require.Nil(t, err)
+	isValid := func(token string) bool {
+		req := httptest.NewRequest("POST", "/api", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		_, validToken := handler.ParseToken(req)
+		return validToken
+	}
+
+	// unknown key id
+	assert.False(t, isValid(newToken))
+	assert.False(t, isValid(noKidToken))
+
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &jwtPrivateKey.PublicKey, KeyID: jwtKeyId, Algorithm: string(jose.ES256), Use: "sig"},
+		{Key: &rotated.PublicKey, KeyID: "rotated", Algorithm: string(jose.ES256), Use: "sig"},
+	}}
+	b, err := json.Marshal(&jwks)
+	require.Nil(t, err)
+	{
+		// both keys are active after loading the set from a file
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.Nil(t, os.WriteFile(path, b, 0600))
+		config.Config.JWKSPath = path
+		require.Nil(t, config.LoadJWTKeys())
+		assert.True(t, isValid(oldToken))
+		assert.True(t, isValid(newToken))
+		assert.True(t, isValid(noKidToken))
+	}
+	{
+		// the old key is retired
+		jwks.Keys = jwks.Keys[1:]
+		b, err := json.Marshal(&jwks)
+		require.Nil(t, err)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(b)
+		}))
+		defer server.Close()
+		config.Config.JWKSPath = server.URL
+		require.Nil(t, config.LoadJWTKeys())
+		assert.False(t, isValid(oldToken))
+		assert.True(t, isValid(newToken))
+	}
+	{
+		// keys are kept if the set fails to load
+		config.Config.JWKSPath = filepath.Join(t.TempDir(), "missing.json")
+		assert.NotNil(t, config.LoadJWTKeys())
+		assert.True(t, isValid(newToken))
+	}
+	{
+		// a single PEM encoded key verifies tokens with any key id
+		der, err := x509.MarshalPKIXPublicKey(&rotated.PublicKey)
+		require.Nil(t, err)
+		path := filepath.Join(t.TempDir(), "jwt.pub")
+		require.Nil(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+		prevPath := config.Config.JWTPublicKeyPath
+		defer func() { config.Config.JWTPublicKeyPath = prevPath }()
+		config.Config.JWKSPath = ""
+		config.Config.JWTPublicKeyPath = path
+		require.Nil(t, config.LoadJWTKeys())
+		assert.True(t, isValid(newToken))
+		assert.True(t, isValid(noKidToken))
+		assert.False(t, isValid(oldToken))
+	}
+}
+
 func TestHandleAddSchedule(t *testing.T) {
 	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
 	{
@@ -348,7 +479,14 @@ func TestHandleAddSchedule(t *testing.T) {
 
 		handler.HandleAddSchedule(w, req)
 		resp := w.Result()
-		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		var conflictResp types.ConflictResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&conflictResp))
+		require.Len(t, conflictResp.Conflicts, 1)
+		assert.Equal(t, "doge", conflictResp.Conflicts[0].Reservee)
+		assert.Equal(t, int64(10000), conflictResp.Conflicts[0].StartTimestamp)
+		assert.Equal(t, int64(11000), conflictResp.Conflicts[0].EndTimestamp)
 	}
 	{
 		// repeat
@@ -372,4 +510,2275 @@ func TestHandleAddSchedule(t *testing.T) {
 		resp := w.Result()
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 	}
+	{
+		// recurrence rule
+		body := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 12000,
+			EndTimestamp:   13000,
+			RRule:          "FREQ=DAILY;INTERVAL=2;COUNT=3",
+		}
+		b, err := json.Marshal(body)
+		require.Nil(t, err)
+		req := httptest.NewRequest("POST", "/api/schedule/add", bytes.NewReader(b))
+		setJWTToken(t, req, userIdx, username, permissionIdx)
+		w := httptest.NewRecorder()
+
+		handler.HandleAddSchedule(w, req)
+		resp := w.Result()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		err = sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			schedules, err := tx.GetSchedules(room.Id, 12000, 12000+5*24*60*60)
+			if err != nil {
+				return err
+			}
+			assert.Len(t, schedules, 3)
+			return nil
+		})
+		require.Nil(t, err)
+	}
+	{
+		// invalid recurrence rule
+		body := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 13000,
+			EndTimestamp:   14000,
+			RRule:          "FREQ=DAILY",
+		}
+		b, err := json.Marshal(body)
+		require.Nil(t, err)
+		req := httptest.NewRequest("POST", "/api/schedule/add", bytes.NewReader(b))
+		setJWTToken(t, req, userIdx, username, permissionIdx)
+		w := httptest.NewRecorder()
+
+		handler.HandleAddSchedule(w, req)
+		resp := w.Result()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// invalid timezone
+		body := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 13000,
+			EndTimestamp:   14000,
+			Repeats:        1,
+			Timezone:       "Mars/Olympus_Mons",
+		}
+		b, err := json.Marshal(body)
+		require.Nil(t, err)
+		req := httptest.NewRequest("POST", "/api/schedule/add", bytes.NewReader(b))
+		setJWTToken(t, req, userIdx, username, permissionIdx)
+		w := httptest.NewRecorder()
+
+		handler.HandleAddSchedule(w, req)
+		resp := w.Result()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestHandleUpdateSchedule(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	room := addRoomForTest(t, "update room")
+
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000,
+		EndTimestamp:   11000,
+		RRule:          "FREQ=DAILY;COUNT=3",
+		Timezone:       "UTC",
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var schedules []*types.Schedule
+	err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		var err error
+		schedules, err = tx.GetSchedules(room.Id, 0, 10*24*60*60)
+		return err
+	})
+	require.Nil(t, err)
+	require.Len(t, schedules, 3)
+	const daySec = 24 * 60 * 60
+
+	{
+		// not the owner
+		body := types.UpdateScheduleReq{
+			ScheduleId:     schedules[0].Id,
+			StartTimestamp: 9000,
+			EndTimestamp:   11000,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// overlapping with another schedule in the group
+		body := types.UpdateScheduleReq{
+			ScheduleId:     schedules[0].Id,
+			StartTimestamp: 10000 + daySec,
+			EndTimestamp:   11000 + daySec,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+	{
+		// shift following schedules onto each other's slots
+		body := types.UpdateScheduleReq{
+			ScheduleId:      schedules[1].Id,
+			StartTimestamp:  10500 + daySec*2,
+			EndTimestamp:    12000 + daySec*2,
+			UpdateFollowing: true,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			kept, err := tx.GetSchedulesInGroup(schedules[0].ScheduleGroupId, 0)
+			if err != nil {
+				return err
+			}
+			require.Len(t, kept, 1)
+			assert.Equal(t, int64(10000), kept[0].StartTimestamp)
+
+			// moved schedules are split into a group with a rule of their own
+			moved, err := tx.GetScheduleById(schedules[1].Id)
+			if err != nil {
+				return err
+			}
+			assert.NotEqual(t, schedules[0].ScheduleGroupId, moved.ScheduleGroupId)
+			following, err := tx.GetSchedulesInGroup(moved.ScheduleGroupId, 0)
+			if err != nil {
+				return err
+			}
+			require.Len(t, following, 2)
+			assert.Equal(t, int64(10500+daySec*2), following[0].StartTimestamp)
+			assert.Equal(t, int64(12000+daySec*2), following[0].EndTimestamp)
+			assert.Equal(t, int64(10500+daySec*3), following[1].StartTimestamp)
+
+			group, err := tx.GetScheduleGroupById(schedules[0].ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "FREQ=DAILY;UNTIL=19700102T024639Z", group.RRule)
+			group, err = tx.GetScheduleGroupById(moved.ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "FREQ=DAILY;UNTIL=19700104T025500Z", group.RRule)
+			assert.Equal(t, "doge", group.Reservee)
+			return nil
+		})
+		require.Nil(t, err)
+	}
+	{
+		// moved schedules overlapping each other are reported
+		body := types.UpdateScheduleReq{
+			ScheduleId:      schedules[1].Id,
+			StartTimestamp:  10500 + daySec*2,
+			EndTimestamp:    11500 + daySec*3,
+			UpdateFollowing: true,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+		var conflictResp types.ConflictResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&conflictResp))
+		assert.Len(t, conflictResp.Conflicts, 2)
+	}
+	{
+		// update schedule info
+		body := types.UpdateScheduleInfoReq{
+			ScheduleGroupId: schedules[0].ScheduleGroupId,
+			Reservee:        "cheems",
+			Email:           "cheems@foo.com",
+			PhoneNumber:     "011",
+			Reason:          "study",
+		}
+		resp := doRequest(t, handler.HandleUpdateScheduleInfo, "POST", "/api/schedule/info/update", body, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			group, err := tx.GetScheduleGroupById(schedules[0].ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "cheems", group.Reservee)
+			assert.Equal(t, "study", group.Reason)
+			return nil
+		})
+		require.Nil(t, err)
+	}
+}
+
+func TestHandleAddScheduleSkipConflicts(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	room := addRoomForTest(t, "skip conflicts room")
+	const daySec = 24 * 60 * 60
+
+	existing := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000 + daySec,
+		EndTimestamp:   11000 + daySec,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", existing, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "cheems",
+		Email:          "cheems@foo.com",
+		PhoneNumber:    "011",
+		Reason:         "study",
+		StartTimestamp: 10500,
+		EndTimestamp:   11500,
+		RRule:          "FREQ=DAILY;COUNT=3",
+		Timezone:       "UTC",
+	}
+	{
+		// fails as a whole without skipConflicts
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", body, 2, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+	{
+		// conflicting occurrence is skipped
+		body.SkipConflicts = true
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", body, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var addResp types.AddScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&addResp))
+		require.Len(t, addResp.Occurrences, 3)
+		assert.True(t, addResp.Occurrences[0].Created)
+		assert.False(t, addResp.Occurrences[1].Created)
+		assert.NotZero(t, addResp.Occurrences[1].ConflictScheduleId)
+		assert.True(t, addResp.Occurrences[2].Created)
+
+		// the skipped occurrence is excluded from the stored rule
+		var g *types.ScheduleGroup
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			g, err = tx.GetScheduleGroupById(addResp.ScheduleGroupId)
+			return err
+		}))
+		assert.Equal(t, []int64{10500 + daySec}, g.ExDates)
+	}
+	{
+		// every occurrence conflicts
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", body, 2, 1)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		var addResp types.AddScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&addResp))
+		require.Len(t, addResp.Occurrences, 3)
+		for _, o := range addResp.Occurrences {
+			assert.False(t, o.Created)
+			assert.NotZero(t, o.ConflictScheduleId)
+		}
+	}
+}
+
+func TestHandleGetAvailability(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	busyRoom := addRoomForTest(t, "busy room")
+	freeRoom := addRoomForTest(t, "free room")
+
+	addReq := types.AddScheduleReq{
+		RoomId:         busyRoom.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000,
+		EndTimestamp:   11000,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	{
+		// missing min duration
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", "/api/rooms/available?startTimestamp=9000&endTimestamp=13000", nil, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", "/api/rooms/available?startTimestamp=9000&endTimestamp=13000&minDuration=1000", nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		require.Len(t, availResp.Rooms, 2)
+		assert.Equal(t, busyRoom.Id, availResp.Rooms[0].Room.Id)
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 9000, EndTimestamp: 10000},
+			{StartTimestamp: 11000, EndTimestamp: 13000},
+		}, availResp.Rooms[0].FreeIntervals)
+		assert.Equal(t, freeRoom.Id, availResp.Rooms[1].Room.Id)
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 9000, EndTimestamp: 13000},
+		}, availResp.Rooms[1].FreeIntervals)
+	}
+	{
+		// filter by category and min duration
+		target := fmt.Sprintf("/api/rooms/available?startTimestamp=9000&endTimestamp=13000&minDuration=1500&categoryId=%d", busyRoom.CategoryId)
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		require.Len(t, availResp.Rooms, 1)
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 11000, EndTimestamp: 13000},
+		}, availResp.Rooms[0].FreeIntervals)
+	}
+	{
+		// not enough seats
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", "/api/rooms/available?startTimestamp=9000&endTimestamp=13000&minDuration=1000&minSeats=20", nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		assert.Len(t, availResp.Rooms, 0)
+	}
+}
+
+func TestHandleGetRoomsSchedule(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	roomA := addRoomForTest(t, "room a")
+	roomB := addRoomForTest(t, "room b")
+	for _, room := range []*types.Room{roomA, roomB} {
+		addReq := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 10000,
+			EndTimestamp:   11000,
+			Repeats:        1,
+		}
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	{
+		// time range is too wide
+		target := fmt.Sprintf("/api/schedule/rooms/get?roomIds=%d&startTimestamp=0&endTimestamp=%d", roomA.Id, int64(config.Config.ScheduleTimeRangeLimit.Seconds())+1)
+		resp := doRequest(t, handler.HandleGetRoomsSchedule, "GET", target, nil, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		target := fmt.Sprintf("/api/schedule/rooms/get?roomIds=%d,%d&startTimestamp=0&endTimestamp=20000", roomA.Id, roomB.Id)
+		resp := doRequest(t, handler.HandleGetRoomsSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var roomsResp types.GetRoomsScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&roomsResp))
+		require.Len(t, roomsResp.Rooms, 2)
+		assert.Equal(t, roomA.Id, roomsResp.Rooms[0].RoomId)
+		assert.Len(t, roomsResp.Rooms[0].Schedules, 1)
+		assert.Equal(t, roomB.Id, roomsResp.Rooms[1].RoomId)
+		assert.Len(t, roomsResp.Rooms[1].Schedules, 1)
+	}
+	{
+		target := fmt.Sprintf("/api/schedule/rooms/get?categoryId=%d&startTimestamp=0&endTimestamp=20000", roomB.CategoryId)
+		resp := doRequest(t, handler.HandleGetRoomsSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var roomsResp types.GetRoomsScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&roomsResp))
+		require.Len(t, roomsResp.Rooms, 1)
+		assert.Equal(t, roomB.Id, roomsResp.Rooms[0].RoomId)
+		assert.Len(t, roomsResp.Rooms[0].Schedules, 1)
+	}
+}
+
+func TestHandleGetMyScheduleGroups(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	room := addRoomForTest(t, "my room")
+	tomorrow := time.Now().Add(24 * time.Hour).Unix()
+	for _, body := range []types.AddScheduleReq{
+		{StartTimestamp: 10000, EndTimestamp: 11000, Repeats: 1},
+		{StartTimestamp: tomorrow, EndTimestamp: tomorrow + 1000, Repeats: 2},
+	} {
+		body.RoomId = room.Id
+		body.Reservee = "doge"
+		body.Email = "doge@foo.com"
+		body.PhoneNumber = "010"
+		body.Reason = "bacchus"
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// someone else's schedule
+		body := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "cheems",
+			Email:          "cheems@foo.com",
+			PhoneNumber:    "011",
+			Reason:         "study",
+			StartTimestamp: 20000,
+			EndTimestamp:   21000,
+			Repeats:        1,
+		}
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", body, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	getGroups := func(target string) []*types.MyScheduleGroup {
+		resp := doRequest(t, handler.HandleGetMyScheduleGroups, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var groupsResp types.GetMyScheduleGroupsResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&groupsResp))
+		return groupsResp.Groups
+	}
+
+	{
+		groups := getGroups("/api/schedule/mine")
+		require.Len(t, groups, 2)
+		assert.Equal(t, 2, groups[0].OccurrenceCount)
+		assert.Equal(t, 1, groups[1].OccurrenceCount)
+	}
+	{
+		groups := getGroups("/api/schedule/mine?when=upcoming")
+		require.Len(t, groups, 1)
+		require.NotNil(t, groups[0].NextOccurrence)
+		assert.Equal(t, tomorrow, groups[0].NextOccurrence.StartTimestamp)
+		assert.Len(t, groups[0].UpcomingSchedules, 2)
+	}
+	{
+		groups := getGroups("/api/schedule/mine?when=past")
+		require.Len(t, groups, 1)
+		assert.Nil(t, groups[0].NextOccurrence)
+		assert.Len(t, groups[0].UpcomingSchedules, 0)
+	}
+	{
+		groups := getGroups("/api/schedule/mine?page=1&pageSize=1")
+		require.Len(t, groups, 1)
+		assert.Equal(t, 1, groups[0].OccurrenceCount)
+		assert.Nil(t, groups[0].NextOccurrence)
+	}
+	{
+		resp := doRequest(t, handler.HandleGetMyScheduleGroups, "GET", "/api/schedule/mine?when=tomorrow", nil, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestScheduleApproval(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "waitlist_entries"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+
+	room := &types.Room{
+		Name:             "seminar room",
+		Seats:            30,
+		RequiresApproval: true,
+	}
+	err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		return tx.AddRoom(room)
+	})
+	require.Nil(t, err)
+
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000,
+		EndTimestamp:   11000,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	getSchedules := func() []*types.Schedule {
+		var schedules []*types.Schedule
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, 0, 20000)
+			return err
+		})
+		require.Nil(t, err)
+		return schedules
+	}
+	schedules := getSchedules()
+	require.Len(t, schedules, 1)
+	assert.Equal(t, types.ScheduleGroupStatusPending, schedules[0].Status)
+
+	{
+		// pending schedule holds the slot
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 2, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+	{
+		// admin only
+		resp := doRequest(t, handler.HandleGetPendingScheduleGroups, "GET", "/api/schedule/pending/get", nil, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		resp := doRequest(t, handler.HandleGetPendingScheduleGroups, "GET", "/api/schedule/pending/get", nil, 3, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var pendingResp types.GetPendingScheduleGroupsResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&pendingResp))
+		require.Len(t, pendingResp.Groups, 1)
+		assert.Equal(t, schedules[0].ScheduleGroupId, pendingResp.Groups[0].Id)
+		assert.Len(t, pendingResp.Groups[0].Schedules, 1)
+	}
+	{
+		// reason is required
+		body := types.RejectScheduleGroupReq{ScheduleGroupId: schedules[0].ScheduleGroupId}
+		resp := doRequest(t, handler.HandleRejectScheduleGroup, "POST", "/api/schedule/reject", body, 3, adminPermissionIdx)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// rejection frees the slot
+		body := types.RejectScheduleGroupReq{
+			ScheduleGroupId: schedules[0].ScheduleGroupId,
+			Reason:          "under maintenance",
+		}
+		resp := doRequest(t, handler.HandleRejectScheduleGroup, "POST", "/api/schedule/reject", body, 3, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, getSchedules(), 0)
+
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			group, err := tx.GetScheduleGroupById(schedules[0].ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, types.ScheduleGroupStatusRejected, group.Status)
+			assert.Equal(t, "under maintenance", group.StatusReason)
+			return nil
+		})
+		require.Nil(t, err)
+	}
+	{
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+
+		body := types.ApproveScheduleGroupReq{
+			ScheduleGroupId: schedules[0].ScheduleGroupId,
+			Reason:          "bring your own projector",
+		}
+		resp = doRequest(t, handler.HandleApproveScheduleGroup, "POST", "/api/schedule/approve", body, 3, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.ScheduleGroupStatusApproved, getSchedules()[0].Status)
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			group, err := tx.GetScheduleGroupById(schedules[0].ScheduleGroupId)
+			if err != nil {
+				return err
+			}
+			assert.Equal(t, "bring your own projector", group.StatusReason)
+			return nil
+		})
+		require.Nil(t, err)
+
+		// already reviewed
+		resp = doRequest(t, handler.HandleApproveScheduleGroup, "POST", "/api/schedule/approve", body, 3, adminPermissionIdx)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// moving an approved booking needs another approval
+		update := types.UpdateScheduleReq{
+			ScheduleId:     schedules[0].Id,
+			StartTimestamp: 12000,
+			EndTimestamp:   13000,
+		}
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", update, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.ScheduleGroupStatusPending, getSchedules()[0].Status)
+	}
+	{
+		// slots of rejected groups are offered to the waitlist
+		future := time.Now().Add(24 * time.Hour).Unix()
+		req := addReq
+		req.StartTimestamp, req.EndTimestamp = future, future+1000
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 4, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		join := types.JoinWaitlistReq{RoomId: room.Id, Reservee: "waiter", Email: "waiter@foo.com", PhoneNumber: "010", Reason: "bacchus", StartTimestamp: future, EndTimestamp: future + 1000, Promotion: types.WaitlistPromotionBook}
+		resp = doRequest(t, handler.HandleJoinWaitlist, "POST", "/api/waitlist/join", join, 5, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var schedules []*types.Schedule
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, future, future+1000)
+			return err
+		}))
+		require.Len(t, schedules, 1)
+		body := types.RejectScheduleGroupReq{ScheduleGroupId: schedules[0].ScheduleGroupId, Reason: "double booked"}
+		resp = doRequest(t, handler.HandleRejectScheduleGroup, "POST", "/api/schedule/reject", body, 3, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, future, future+1000)
+			return err
+		}))
+		require.Len(t, schedules, 1)
+		assert.NotEqual(t, body.ScheduleGroupId, schedules[0].ScheduleGroupId)
+		assert.Equal(t, types.ScheduleGroupStatusPending, schedules[0].Status)
+	}
+}
+
+func TestBookingPolicy(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "booking_policies"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+	room := addRoomForTest(t, "policy room")
+	const hourSec = 60 * 60
+
+	{
+		// admin only
+		body := types.AddBookingPolicyReq{RoomId: -1, CategoryId: room.CategoryId, MaxDuration: hourSec}
+		resp := doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		// both room and category
+		body := types.AddBookingPolicyReq{RoomId: room.Id, CategoryId: room.CategoryId, MaxDuration: hourSec}
+		resp := doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	var categoryPolicy types.BookingPolicy
+	{
+		body := types.AddBookingPolicyReq{RoomId: -1, CategoryId: room.CategoryId, MaxDuration: hourSec}
+		resp := doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&categoryPolicy))
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour).Truncate(time.Hour).Unix()
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: tomorrow,
+		EndTimestamp:   tomorrow + 2*hourSec,
+		Repeats:        1,
+	}
+	{
+		// longer than max duration of category
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// admins are exempt
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// room policy overrides category policy
+		body := types.AddBookingPolicyReq{RoomId: room.Id, CategoryId: -1, SlotGranularity: hourSec}
+		resp := doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		addReq.StartTimestamp = tomorrow + 2*hourSec
+		addReq.EndTimestamp = tomorrow + 5*hourSec
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		addReq.StartTimestamp = tomorrow + 5*hourSec + 60
+		addReq.EndTimestamp = tomorrow + 6*hourSec
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		body := types.UpdateBookingPolicyReq{PolicyId: categoryPolicy.Id, MaxDaysInAdvance: 7}
+		resp := doRequest(t, handler.HandleUpdateBookingPolicy, "POST", "/api/policies/update", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleGetBookingPolicies, "GET", "/api/policies/get", nil, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var policiesResp types.GetBookingPoliciesResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&policiesResp))
+		require.Len(t, policiesResp.Policies, 2)
+		assert.Equal(t, 7, policiesResp.Policies[0].MaxDaysInAdvance)
+		assert.Equal(t, int64(0), policiesResp.Policies[0].MaxDuration)
+	}
+	{
+		body := types.DeleteBookingPolicyReq{PolicyId: categoryPolicy.Id}
+		resp := doRequest(t, handler.HandleDeleteBookingPolicy, "POST", "/api/policies/delete", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleDeleteBookingPolicy, "POST", "/api/policies/delete", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestOpeningHoursAndClosures(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "opening_hours", "closures"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+	room := addRoomForTest(t, "closure room")
+	loc := config.Config.DefaultLocation
+
+	// next monday
+	now := time.Now().In(loc)
+	monday := time.Date(now.Year(), now.Month(), now.Day()+7-(int(now.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	at := func(day int, hour int) int64 {
+		return time.Date(monday.Year(), monday.Month(), monday.Day()+day, hour, 0, 0, 0, loc).Unix()
+	}
+
+	{
+		body := types.SetOpeningHoursReq{
+			RoomId: room.Id,
+			OpeningHours: []*types.OpeningHours{
+				{Weekday: int(time.Monday), OpenTime: 9 * 60 * 60, CloseTime: 18 * 60 * 60},
+				{Weekday: int(time.Tuesday), OpenTime: 9 * 60 * 60, CloseTime: 18 * 60 * 60},
+			},
+		}
+		resp := doRequest(t, handler.HandleSetOpeningHours, "POST", "/api/rooms/hours/set", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		body := types.AddClosureReq{
+			RoomId:         -1,
+			CategoryId:     room.CategoryId,
+			StartTimestamp: at(1, 0),
+			EndTimestamp:   at(2, 0),
+			Reason:         "holiday",
+		}
+		resp := doRequest(t, handler.HandleAddClosure, "POST", "/api/closures/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	addReq := types.AddScheduleReq{
+		RoomId:      room.Id,
+		Reservee:    "doge",
+		Email:       "doge@foo.com",
+		PhoneNumber: "010",
+		Reason:      "bacchus",
+		Repeats:     1,
+	}
+	for _, c := range []struct {
+		startTimestamp int64
+		endTimestamp   int64
+		statusCode     int
+	}{
+		// outside opening hours
+		{at(0, 8), at(0, 10), http.StatusBadRequest},
+		// wednesday has no opening hours
+		{at(2, 10), at(2, 11), http.StatusBadRequest},
+		// closed
+		{at(1, 10), at(1, 11), http.StatusBadRequest},
+		{at(0, 10), at(0, 11), http.StatusOK},
+	} {
+		addReq.StartTimestamp = c.startTimestamp
+		addReq.EndTimestamp = c.endTimestamp
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		assert.Equal(t, c.statusCode, resp.StatusCode)
+	}
+
+	{
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", room.Id, at(0, 0), at(7, 0))
+		resp := doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		assert.Len(t, scheduleResp.Schedules, 1)
+		require.Len(t, scheduleResp.Closures, 1)
+		assert.Equal(t, "holiday", scheduleResp.Closures[0].Reason)
+		assert.Equal(t, at(1, 0), scheduleResp.Closures[0].StartTimestamp)
+	}
+}
+
+func TestBookingQuota(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "booking_quotas"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+	room := addRoomForTest(t, "quota room")
+	loc := config.Config.DefaultLocation
+	const hourSec = 60 * 60
+
+	// next monday
+	now := time.Now().In(loc)
+	monday := time.Date(now.Year(), now.Month(), now.Day()+7-(int(now.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	at := func(day int, hour int) int64 {
+		return time.Date(monday.Year(), monday.Month(), monday.Day()+day, hour, 0, 0, 0, loc).Unix()
+	}
+	addReq := func(start int64, end int64, repeats int) types.AddScheduleReq {
+		return types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Repeats:        repeats,
+		}
+	}
+
+	var quota types.BookingQuota
+	{
+		// admin only
+		body := types.AddBookingQuotaReq{RoomId: room.Id, CategoryId: -1, MaxHoursPerWeek: 3}
+		resp := doRequest(t, handler.HandleAddBookingQuota, "POST", "/api/quotas/add", body, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleAddBookingQuota, "POST", "/api/quotas/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&quota))
+	}
+	{
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(0, 10), at(0, 12), 1), 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// exceeds the weekly hours
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(2, 10), at(2, 12), 1), 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		// moved schedules are counted at their new length only
+		var schedules []*types.Schedule
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, at(0, 0), at(1, 0))
+			return err
+		}))
+		require.Len(t, schedules, 1)
+		update := types.UpdateScheduleReq{ScheduleId: schedules[0].Id, StartTimestamp: at(0, 10), EndTimestamp: at(0, 13)}
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", update, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		update.EndTimestamp = at(0, 14)
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", update, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		update.EndTimestamp = at(0, 12)
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", update, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// other users and admins are not affected
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(2, 10), at(2, 12), 1), 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(3, 10), at(3, 14), 1), 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// the next week has its own hours
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(7, 10), at(7, 12), 1), 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		body := types.UpdateBookingQuotaReq{QuotaId: quota.Id, MaxRecurringGroups: 1}
+		resp := doRequest(t, handler.HandleUpdateBookingQuota, "POST", "/api/quotas/update", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(1, 10), at(1, 12), 2), 3, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(1, 14), at(1, 16), 2), 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		// single bookings are not recurring
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(1, 14), at(1, 16), 1), 3, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// quota of category applies together with quota of room
+		body := types.AddBookingQuotaReq{RoomId: -1, CategoryId: room.CategoryId, MaxFutureBookings: 2}
+		resp := doRequest(t, handler.HandleAddBookingQuota, "POST", "/api/quotas/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleGetBookingQuotas, "GET", "/api/quotas/get", nil, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var quotasResp types.GetBookingQuotasResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&quotasResp))
+		require.Len(t, quotasResp.Quotas, 2)
+		assert.Equal(t, 0, quotasResp.Quotas[0].MaxHoursPerWeek)
+		assert.Equal(t, 1, quotasResp.Quotas[0].MaxRecurringGroups)
+
+		// user 3 already has three upcoming bookings
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(4, 10), at(4, 11), 1), 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// concurrent requests cannot exceed the quota together
+		var wg sync.WaitGroup
+		codes := make([]int, 4)
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(5, 10+i), at(5, 11+i), 1), 4, 1)
+				codes[i] = resp.StatusCode
+			}(i)
+		}
+		wg.Wait()
+		succeeded := 0
+		for _, code := range codes {
+			if code == http.StatusOK {
+				succeeded++
+			}
+		}
+		assert.Equal(t, 2, succeeded)
+	}
+	{
+		// skipped occurrences do not count against the quota
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(at(13, 10), at(13, 11), 1), 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		req := addReq(at(6, 10), at(6, 11), 3)
+		req.SkipConflicts = true
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 5, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var addResp types.AddScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&addResp))
+		require.Len(t, addResp.Occurrences, 3)
+		assert.False(t, addResp.Occurrences[1].Created)
+
+		req = addReq(at(4, 14), at(4, 15), 1)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 5, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		body := types.DeleteBookingQuotaReq{QuotaId: quota.Id}
+		resp := doRequest(t, handler.HandleDeleteBookingQuota, "POST", "/api/quotas/delete", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleDeleteBookingQuota, "POST", "/api/quotas/delete", body, 1, adminPermissionIdx)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestSharedRoomSeats(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	exclusiveRoom := addRoomForTest(t, "exclusive room")
+	room := &types.Room{Name: "shared room", Seats: 3, CategoryId: exclusiveRoom.CategoryId, Shared: true}
+	require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		return tx.AddRoom(room)
+	}))
+
+	addReq := func(roomId int64, start int64, end int64, seats int, seat int) types.AddScheduleReq {
+		return types.AddScheduleReq{
+			RoomId:         roomId,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Repeats:        1,
+			Seats:          seats,
+			Seat:           seat,
+		}
+	}
+	{
+		// exclusive rooms are booked as a whole
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(exclusiveRoom.Id, 10000, 12000, 2, 0), 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 10000, 12000, 2, 0), 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 11000, 13000, 0, 1), 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// every seat is taken between 11000 and 12000
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 11000, 12000, 1, 0), 3, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 12000, 13000, 1, 0), 3, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// the seat is already taken
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 12500, 14000, 0, 1), 4, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 12500, 14000, 0, 2), 4, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// invalid seats
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 20000, 21000, 4, 0), 4, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 20000, 21000, 0, 4), 4, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 20000, 21000, 2, 1), 4, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=9000&endTimestamp=15000", room.Id)
+	resp := doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var scheduleResp types.GetScheduleResp
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+	require.Len(t, scheduleResp.Schedules, 4)
+	var first, second types.Schedule
+	for _, s := range scheduleResp.Schedules {
+		switch s.StartTimestamp {
+		case 10000:
+			first = *s
+		case 11000:
+			second = *s
+		}
+	}
+	assert.Equal(t, 2, first.Seats)
+	assert.Equal(t, 1, second.Seats)
+	assert.Equal(t, 1, second.Seat)
+	{
+		// moving the first schedule would take four seats between 12000 and 13000
+		body := types.UpdateScheduleReq{ScheduleId: first.Id, StartTimestamp: 11000, EndTimestamp: 13000}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		body = types.UpdateScheduleReq{ScheduleId: first.Id, StartTimestamp: 9000, EndTimestamp: 11000}
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// a shared room is free while it has enough seats
+		target := fmt.Sprintf("/api/rooms/available?startTimestamp=9000&endTimestamp=15000&minDuration=500&minSeats=2&categoryId=%d", room.CategoryId)
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		require.Len(t, availResp.Rooms, 2)
+		assert.Equal(t, room.Id, availResp.Rooms[1].Room.Id)
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 11000, EndTimestamp: 12000},
+			{StartTimestamp: 13000, EndTimestamp: 15000},
+		}, availResp.Rooms[1].FreeIntervals)
+	}
+	{
+		// seats cannot be lowered below what upcoming bookings take
+		config.Config.AdminPermissionIdx = 100
+		future := time.Now().Add(24 * time.Hour).Unix()
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, future, future+1000, 2, 0), 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, future+2000, future+3000, 0, 3), 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		seats := 2
+		body := types.UpdateRoomReq{RoomId: room.Id, Seats: &seats}
+		resp = doRequest(t, handler.HandleUpdateRoom, "POST", "/api/rooms/update", body, 1, 100)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+		var conflictResp types.ConflictResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&conflictResp))
+		require.Len(t, conflictResp.Conflicts, 1)
+		assert.Equal(t, future+2000, conflictResp.Conflicts[0].StartTimestamp)
+
+		seats = 1
+		resp = doRequest(t, handler.HandleUpdateRoom, "POST", "/api/rooms/update", body, 1, 100)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&conflictResp))
+		assert.Len(t, conflictResp.Conflicts, 2)
+	}
+}
+
+func TestWaitlist(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "waitlist_entries", "booking_quotas"))
+	room := addRoomForTest(t, "waitlist room")
+	const hourSec = 60 * 60
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Hour).Unix()
+
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: start,
+		EndTimestamp:   start + 2*hourSec,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	join := func(userIdx int, startTimestamp int64, endTimestamp int64, promotion string) types.WaitlistEntry {
+		body := types.JoinWaitlistReq{
+			RoomId:         room.Id,
+			Reservee:       "waiter",
+			Email:          "waiter@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			Promotion:      promotion,
+		}
+		resp := doRequest(t, handler.HandleJoinWaitlist, "POST", "/api/waitlist/join", body, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var entry types.WaitlistEntry
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&entry))
+		return entry
+	}
+	getEntry := func(userIdx int, entryId int64) *types.WaitlistEntry {
+		resp := doRequest(t, handler.HandleGetMyWaitlistEntries, "GET", "/api/waitlist/mine", nil, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var entriesResp types.GetMyWaitlistEntriesResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&entriesResp))
+		for _, entry := range entriesResp.Entries {
+			if entry.Id == entryId {
+				return entry
+			}
+		}
+		require.FailNow(t, "waitlist entry not found")
+		return nil
+	}
+	getSchedules := func() []*types.Schedule {
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", room.Id, start, start+4*hourSec)
+		resp := doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		return scheduleResp.Schedules
+	}
+
+	{
+		// invalid promotion and past slot
+		body := types.JoinWaitlistReq{RoomId: room.Id, Reservee: "waiter", Email: "waiter@foo.com", PhoneNumber: "010", Reason: "bacchus", StartTimestamp: start, EndTimestamp: start + hourSec, Promotion: "steal"}
+		resp := doRequest(t, handler.HandleJoinWaitlist, "POST", "/api/waitlist/join", body, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body.Promotion = ""
+		body.StartTimestamp, body.EndTimestamp = 10000, 11000
+		resp = doRequest(t, handler.HandleJoinWaitlist, "POST", "/api/waitlist/join", body, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	holdEntry := join(2, start, start+hourSec, "")
+	bookEntry := join(3, start, start+2*hourSec, types.WaitlistPromotionBook)
+	assert.Equal(t, types.WaitlistStatusWaiting, holdEntry.Status)
+	assert.Equal(t, types.WaitlistPromotionHold, holdEntry.Promotion)
+
+	schedules := getSchedules()
+	require.Len(t, schedules, 1)
+	{
+		// the first waiter is offered the slot, and the second keeps waiting
+		body := types.DeleteScheduleReq{ScheduleId: schedules[0].Id}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		entry := getEntry(2, holdEntry.Id)
+		assert.Equal(t, types.WaitlistStatusOffered, entry.Status)
+		assert.NotEqual(t, int64(-1), entry.ScheduleGroupId)
+		assert.Equal(t, types.WaitlistStatusWaiting, getEntry(3, bookEntry.Id).Status)
+
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, types.ScheduleGroupStatusHeld, schedules[0].Status)
+
+		// only the waiter confirms
+		confirm := types.ConfirmWaitlistEntryReq{EntryId: holdEntry.Id}
+		resp = doRequest(t, handler.HandleConfirmWaitlistEntry, "POST", "/api/waitlist/confirm", confirm, 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleConfirmWaitlistEntry, "POST", "/api/waitlist/confirm", confirm, 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.WaitlistStatusBooked, getEntry(2, holdEntry.Id).Status)
+		assert.Equal(t, types.ScheduleGroupStatusApproved, getSchedules()[0].Status)
+	}
+	{
+		// the second waiter is booked directly
+		body := types.DeleteScheduleReq{ScheduleId: getSchedules()[0].Id}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.Equal(t, types.WaitlistStatusBooked, getEntry(3, bookEntry.Id).Status)
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, types.ScheduleGroupStatusApproved, schedules[0].Status)
+		assert.Equal(t, start+2*hourSec, schedules[0].EndTimestamp)
+	}
+	{
+		// holds which are not confirmed in time are offered to the next waiter
+		prevTTL := config.Config.WaitlistHoldTTL
+		config.Config.WaitlistHoldTTL = -time.Minute
+		defer func() { config.Config.WaitlistHoldTTL = prevTTL }()
+
+		first := join(4, start, start+hourSec, "")
+		second := join(5, start+hourSec, start+2*hourSec, "")
+		body := types.DeleteScheduleReq{ScheduleId: getSchedules()[0].Id}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 3, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.WaitlistStatusOffered, getEntry(4, first.Id).Status)
+		assert.Equal(t, types.WaitlistStatusOffered, getEntry(5, second.Id).Status)
+
+		confirm := types.ConfirmWaitlistEntryReq{EntryId: first.Id}
+		resp = doRequest(t, handler.HandleConfirmWaitlistEntry, "POST", "/api/waitlist/confirm", confirm, 4, 1)
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		third := join(6, start, start+hourSec, types.WaitlistPromotionBook)
+		require.Nil(t, handler.SweepExpiredHolds())
+		assert.Equal(t, types.WaitlistStatusExpired, getEntry(4, first.Id).Status)
+		assert.Equal(t, types.WaitlistStatusExpired, getEntry(5, second.Id).Status)
+		assert.Equal(t, types.WaitlistStatusBooked, getEntry(6, third.Id).Status)
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, start, schedules[0].StartTimestamp)
+	}
+	{
+		// waiters who would exceed their quota keep waiting
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			return tx.AddBookingQuota(&types.BookingQuota{RoomId: room.Id, CategoryId: -1, MaxFutureBookings: 1})
+		}))
+		later := addReq
+		later.StartTimestamp, later.EndTimestamp = start+3*hourSec, start+4*hourSec
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", later, 7, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		entry := join(7, start, start+hourSec, types.WaitlistPromotionBook)
+
+		var body types.DeleteScheduleReq
+		for _, s := range getSchedules() {
+			if s.StartTimestamp == start {
+				body.ScheduleId = s.Id
+			}
+		}
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 6, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.WaitlistStatusWaiting, getEntry(7, entry.Id).Status)
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, start+3*hourSec, schedules[0].StartTimestamp)
+	}
+	{
+		// waiters who can no longer book are removed from the waitlist
+		config.Config.AdminPermissionIdx = 100
+		later := addReq
+		later.StartTimestamp, later.EndTimestamp = start+5*hourSec, start+6*hourSec
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", later, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		entry := join(8, start+5*hourSec, start+6*hourSec, types.WaitlistPromotionBook)
+
+		config.Config.DefaultRole = types.RoleViewer
+		defer func() { config.Config.DefaultRole = types.RoleBooker }()
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", room.Id, start+5*hourSec, start+6*hourSec)
+		resp = doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		require.Len(t, scheduleResp.Schedules, 1)
+
+		body := types.DeleteScheduleReq{ScheduleId: scheduleResp.Schedules[0].Id}
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, types.WaitlistStatusExpired, getEntry(8, entry.Id).Status)
+		config.Config.DefaultRole = types.RoleBooker
+	}
+	{
+		body := types.LeaveWaitlistReq{EntryId: holdEntry.Id}
+		resp := doRequest(t, handler.HandleLeaveWaitlist, "POST", "/api/waitlist/leave", body, 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleLeaveWaitlist, "POST", "/api/waitlist/leave", body, 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestScheduleHold(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "waitlist_entries"))
+	room := addRoomForTest(t, "hold room")
+
+	holdReq := types.HoldScheduleReq{RoomId: room.Id, StartTimestamp: 10000, EndTimestamp: 11000}
+	hold := func(userIdx int) (*http.Response, types.HoldScheduleResp) {
+		resp := doRequest(t, handler.HandleHoldSchedule, "POST", "/api/schedule/hold", holdReq, userIdx, 1)
+		var holdResp types.HoldScheduleResp
+		if resp.StatusCode == http.StatusOK {
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&holdResp))
+		}
+		return resp, holdResp
+	}
+	getSchedules := func() []*types.Schedule {
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=9000&endTimestamp=12000", room.Id)
+		resp := doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		return scheduleResp.Schedules
+	}
+	confirmReq := types.ConfirmHoldReq{
+		Reservee:    "doge",
+		Email:       "doge@foo.com",
+		PhoneNumber: "010",
+		Reason:      "bacchus",
+	}
+
+	{
+		resp, holdResp := hold(1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Greater(t, holdResp.ExpiresAt, time.Now().Unix())
+
+		// held slot is occupied
+		resp, _ = hold(2)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		schedules := getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, types.ScheduleGroupStatusHeld, schedules[0].Status)
+
+		confirmReq.ScheduleGroupId = holdResp.ScheduleGroupId
+		resp = doRequest(t, handler.HandleConfirmHold, "POST", "/api/schedule/hold/confirm", confirmReq, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		missing := confirmReq
+		missing.Reason = ""
+		resp = doRequest(t, handler.HandleConfirmHold, "POST", "/api/schedule/hold/confirm", missing, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleConfirmHold, "POST", "/api/schedule/hold/confirm", confirmReq, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		schedules = getSchedules()
+		require.Len(t, schedules, 1)
+		assert.Equal(t, types.ScheduleGroupStatusApproved, schedules[0].Status)
+		assert.Equal(t, "doge", schedules[0].Reservee)
+
+		// confirmed groups cannot be released as holds
+		resp = doRequest(t, handler.HandleReleaseHold, "POST", "/api/schedule/hold/release", types.ReleaseHoldReq{ScheduleGroupId: holdResp.ScheduleGroupId}, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		body := types.DeleteScheduleReq{ScheduleId: schedules[0].Id}
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		resp, holdResp := hold(1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleReleaseHold, "POST", "/api/schedule/hold/release", types.ReleaseHoldReq{ScheduleGroupId: holdResp.ScheduleGroupId}, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleReleaseHold, "POST", "/api/schedule/hold/release", types.ReleaseHoldReq{ScheduleGroupId: holdResp.ScheduleGroupId}, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, getSchedules(), 0)
+	}
+	{
+		// expired holds cannot be confirmed and are swept
+		prevTTL := config.Config.HoldTTL
+		config.Config.HoldTTL = -time.Minute
+		resp, holdResp := hold(1)
+		config.Config.HoldTTL = prevTTL
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		confirmReq.ScheduleGroupId = holdResp.ScheduleGroupId
+		resp = doRequest(t, handler.HandleConfirmHold, "POST", "/api/schedule/hold/confirm", confirmReq, 1, 1)
+		assert.Equal(t, http.StatusGone, resp.StatusCode)
+
+		require.Nil(t, handler.SweepExpiredHolds())
+		assert.Len(t, getSchedules(), 0)
+		resp, _ = hold(2)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestCheckIn(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "waitlist_entries", "no_shows"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+	base := addRoomForTest(t, "check-in base room")
+	rooms := make([]*types.Room, 2)
+	for i := range rooms {
+		rooms[i] = &types.Room{Name: fmt.Sprintf("check-in room %d", i), Seats: 10, CategoryId: base.CategoryId, RequiresCheckIn: true}
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			return tx.AddRoom(rooms[i])
+		}))
+	}
+
+	now := time.Now().Unix()
+	const minuteSec = 60
+	book := func(userIdx int, roomId int64, start int64, end int64) *types.Schedule {
+		addReq := types.AddScheduleReq{
+			RoomId:         roomId,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Repeats:        1,
+		}
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", roomId, start, end)
+		resp = doRequest(t, handler.HandleGetSchedule, "GET", target, nil, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		require.Len(t, scheduleResp.Schedules, 1)
+		return scheduleResp.Schedules[0]
+	}
+	noShow := book(1, rooms[0].Id, now-30*minuteSec, now+30*minuteSec)
+	kiosk := book(2, rooms[1].Id, now-30*minuteSec, now+30*minuteSec)
+	owner := book(2, rooms[1].Id, now+30*minuteSec, now+60*minuteSec)
+	future := book(2, rooms[1].Id, now+120*minuteSec, now+180*minuteSec)
+
+	var kioskToken string
+	{
+		body := types.IssueKioskTokenReq{RoomId: rooms[1].Id}
+		resp := doRequest(t, handler.HandleIssueKioskToken, "POST", "/api/rooms/kiosk/issue", body, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = doRequest(t, handler.HandleIssueKioskToken, "POST", "/api/rooms/kiosk/issue", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var tokenResp types.IssueKioskTokenResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&tokenResp))
+		kioskToken = tokenResp.Token
+	}
+	{
+		// kiosk token is tied to the room
+		resp := doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: noShow.Id, KioskToken: kioskToken}, 0, 0)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: kiosk.Id, KioskToken: "wrong"}, 0, 0)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: kiosk.Id, KioskToken: kioskToken}, 0, 0)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		// already checked in
+		resp = doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: kiosk.Id, KioskToken: kioskToken}, 0, 0)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// only the owner checks in, within the grace period before the start
+		resp := doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: owner.Id}, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: future.Id}, 2, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		prevGrace := config.Config.CheckInGracePeriod
+		config.Config.CheckInGracePeriod = 45 * time.Minute
+		resp = doRequest(t, handler.HandleCheckIn, "POST", "/api/schedule/checkin", types.CheckInReq{ScheduleId: owner.Id}, 2, 1)
+		config.Config.CheckInGracePeriod = prevGrace
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		require.Nil(t, handler.ReleaseNoShows())
+
+		target := fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", rooms[0].Id, now-60*minuteSec, now+60*minuteSec)
+		resp := doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var scheduleResp types.GetScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		assert.Len(t, scheduleResp.Schedules, 0)
+
+		target = fmt.Sprintf("/api/schedule/get?roomId=%d&startTimestamp=%d&endTimestamp=%d", rooms[1].Id, now-60*minuteSec, now+240*minuteSec)
+		resp = doRequest(t, handler.HandleGetSchedule, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&scheduleResp))
+		assert.Len(t, scheduleResp.Schedules, 3)
+
+		resp = doRequest(t, handler.HandleGetNoShows, "GET", "/api/schedule/noshows/get", nil, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = doRequest(t, handler.HandleGetNoShows, "GET", "/api/schedule/noshows/get", nil, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var noShowsResp types.GetNoShowsResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&noShowsResp))
+		assert.Equal(t, []*types.UserNoShows{{UserIdx: 1, NoShowCount: 1}}, noShowsResp.Users)
+
+		// no-shows are kept after the room and its bookings are deleted
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			return tx.DeleteRoom(rooms[0].Id)
+		}))
+		resp = doRequest(t, handler.HandleGetNoShows, "GET", "/api/schedule/noshows/get", nil, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&noShowsResp))
+		assert.Equal(t, []*types.UserNoShows{{UserIdx: 1, NoShowCount: 1}}, noShowsResp.Users)
+	}
+}
+
+func TestRoomBuffers(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	other := addRoomForTest(t, "other room")
+	room := &types.Room{Name: "buffered room", Seats: 10, CategoryId: other.CategoryId, BufferBefore: 600, BufferAfter: 300}
+	require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		return tx.AddRoom(room)
+	}))
+
+	addReq := func(start int64, end int64) types.AddScheduleReq {
+		return types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Repeats:        1,
+		}
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(10000, 11000), 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	{
+		// the setup time of the next booking overlaps the cleanup time
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(11500, 12000), 2, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(8000, 9500), 2, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(11900, 13000), 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	var schedules []*types.Schedule
+	require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		var err error
+		schedules, err = tx.GetSchedules(room.Id, 0, 20000)
+		return err
+	}))
+	require.Len(t, schedules, 2)
+	// bookings keep their own times
+	assert.Equal(t, int64(10000), schedules[0].StartTimestamp)
+	assert.Equal(t, int64(11000), schedules[0].EndTimestamp)
+
+	{
+		// moving a booking keeps the buffers
+		body := types.UpdateScheduleReq{
+			ScheduleId:     schedules[0].Id,
+			StartTimestamp: 10500,
+			EndTimestamp:   11500,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		body.StartTimestamp = 9000
+		body.EndTimestamp = 10000
+		resp = doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		target := fmt.Sprintf("/api/rooms/available?startTimestamp=8000&endTimestamp=14000&minDuration=100&categoryId=%d", room.CategoryId)
+		resp := doRequest(t, handler.HandleGetAvailability, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		require.Len(t, availResp.Rooms, 2)
+		assert.Equal(t, room.Id, availResp.Rooms[1].Room.Id)
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 8000, EndTimestamp: 8700},
+			{StartTimestamp: 10600, EndTimestamp: 11600},
+			{StartTimestamp: 13600, EndTimestamp: 14000},
+		}, availResp.Rooms[1].FreeIntervals)
+	}
+	{
+		// seats of shared rooms are taken during the buffers too
+		desks := &types.Room{Name: "buffered desks", Seats: 2, CategoryId: other.CategoryId, Shared: true, BufferBefore: 600, BufferAfter: 300}
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			return tx.AddRoom(desks)
+		}))
+		req := addReq(20000, 21000)
+		req.RoomId, req.Seats = desks.Id, 2
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		req = addReq(21100, 22000)
+		req.RoomId, req.Seats = desks.Id, 1
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 2, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		req.StartTimestamp, req.EndTimestamp = 21900, 22500
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// free intervals leave out the slots the buffers keep from a seat
+		target := fmt.Sprintf("/api/rooms/available?startTimestamp=18000&endTimestamp=24000&minSeats=1&categoryId=%d", desks.CategoryId)
+		resp = doRequest(t, handler.HandleGetAvailability, "GET", target, nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var availResp types.GetAvailabilityResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&availResp))
+		var free []*types.TimeRange
+		for _, a := range availResp.Rooms {
+			if a.Room.Id == desks.Id {
+				free = a.FreeIntervals
+			}
+		}
+		assert.Equal(t, []*types.TimeRange{
+			{StartTimestamp: 18000, EndTimestamp: 19100},
+			{StartTimestamp: 21900, EndTimestamp: 24000},
+		}, free)
+	}
+}
+
+func TestMultiRoomSchedule(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "booking_quotas"))
+	config.Config.AdminPermissionIdx = 100
+	const adminPermissionIdx = 100
+	hall := addRoomForTest(t, "main hall")
+	breakout := addRoomForTest(t, "breakout room")
+
+	addReq := func(roomIds []int64, start int64, end int64) types.AddScheduleReq {
+		return types.AddScheduleReq{
+			RoomIds:        roomIds,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Repeats:        2,
+		}
+	}
+	getSchedules := func(roomId int64) []*types.Schedule {
+		var schedules []*types.Schedule
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(roomId, 0, 30*24*60*60)
+			return err
+		}))
+		sort.Slice(schedules, func(i, j int) bool { return schedules[i].StartTimestamp < schedules[j].StartTimestamp })
+		return schedules
+	}
+	const weekSec = 7 * 24 * 60 * 60
+
+	{
+		// conflicts in one room fail the whole booking
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{breakout.Id}, 10000+weekSec, 11000+weekSec), 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{hall.Id, breakout.Id}, 10000, 11000), 1, 1)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Len(t, getSchedules(hall.Id), 0)
+		require.Nil(t, sql.TruncateForTest("schedule_groups", "schedules"))
+	}
+	{
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{hall.Id, hall.Id}, 10000, 11000), 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		req := addReq([]int64{hall.Id, breakout.Id}, 10000, 11000)
+		req.SkipConflicts = true
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", req, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{hall.Id, breakout.Id}, 10000, 11000), 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	hallSchedules := getSchedules(hall.Id)
+	breakoutSchedules := getSchedules(breakout.Id)
+	require.Len(t, hallSchedules, 2)
+	require.Len(t, breakoutSchedules, 2)
+	assert.Equal(t, hallSchedules[0].ScheduleGroupId, breakoutSchedules[0].ScheduleGroupId)
+
+	{
+		// moving an occurrence moves it in every room
+		body := types.UpdateScheduleReq{
+			ScheduleId:     hallSchedules[0].Id,
+			StartTimestamp: 12000,
+			EndTimestamp:   13000,
+		}
+		resp := doRequest(t, handler.HandleUpdateSchedule, "POST", "/api/schedule/update", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int64(12000), getSchedules(hall.Id)[0].StartTimestamp)
+		assert.Equal(t, int64(12000), getSchedules(breakout.Id)[0].StartTimestamp)
+	}
+	{
+		// deleting an occurrence deletes it in every room
+		body := types.DeleteScheduleReq{ScheduleId: breakoutSchedules[1].Id}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, getSchedules(hall.Id), 1)
+		assert.Len(t, getSchedules(breakout.Id), 1)
+	}
+	{
+		body := types.DeleteScheduleReq{ScheduleId: hallSchedules[0].Id, DeleteAllInGroup: true}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, getSchedules(hall.Id), 0)
+		assert.Len(t, getSchedules(breakout.Id), 0)
+	}
+	{
+		// quota of a category counts every room of the category booked at once
+		annex := &types.Room{Name: "annex", CategoryId: hall.CategoryId, Seats: 10}
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			return tx.AddRoom(annex)
+		}))
+		body := types.AddBookingQuotaReq{RoomId: -1, CategoryId: hall.CategoryId, MaxHoursPerWeek: 1}
+		resp := doRequest(t, handler.HandleAddBookingQuota, "POST", "/api/quotas/add", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{hall.Id, annex.Id}, 10000, 12400), 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Len(t, getSchedules(hall.Id), 0)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq([]int64{hall.Id, breakout.Id}, 10000, 12400), 1, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// deleting a room keeps the schedules in the other rooms
+		body := types.DeleteRoomReq{RoomId: hall.Id, Force: true}
+		resp := doRequest(t, handler.HandleDeleteRoom, "POST", "/api/rooms/delete", body, 1, adminPermissionIdx)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		schedules := getSchedules(breakout.Id)
+		require.Len(t, schedules, 2)
+
+		var g *types.ScheduleGroup
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			g, err = tx.GetScheduleGroupById(schedules[0].ScheduleGroupId)
+			return err
+		}))
+		assert.Equal(t, breakout.Id, g.RoomId)
+	}
+}
+
+func TestUpdateRoomAndCategory(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules"))
+	config.Config.AdminPermissionIdx = 100
+	room := addRoomForTest(t, "old room")
+	other := addRoomForTest(t, "other room")
+
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000,
+		EndTimestamp:   11000,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	name := "new room"
+	seats := 20
+	{
+		// admin only
+		body := types.UpdateRoomReq{RoomId: room.Id, Name: &name}
+		resp := doRequest(t, handler.HandleUpdateRoom, "POST", "/api/rooms/update", body, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		body := types.UpdateRoomReq{RoomId: room.Id, Name: &other.Name}
+		resp := doRequest(t, handler.HandleUpdateRoom, "POST", "/api/rooms/update", body, 1, 100)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+	{
+		body := types.UpdateRoomReq{RoomId: room.Id, Name: &name, Seats: &seats, CategoryId: &other.CategoryId}
+		resp := doRequest(t, handler.HandleUpdateRoom, "POST", "/api/rooms/update", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var updated types.Room
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&updated))
+		assert.Equal(t, name, updated.Name)
+		assert.Equal(t, seats, updated.Seats)
+		assert.Equal(t, other.CategoryId, updated.CategoryId)
+	}
+	{
+		// schedules are kept
+		var schedules []*types.Schedule
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, 0, 20000)
+			return err
+		}))
+		assert.Len(t, schedules, 1)
+	}
+
+	description := "new description"
+	{
+		otherName := "other room category"
+		body := types.UpdateCategoryReq{CategoryId: room.CategoryId, Name: &otherName}
+		resp := doRequest(t, handler.HandleUpdateCategory, "POST", "/api/categories/update", body, 1, 100)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+	{
+		body := types.UpdateCategoryReq{CategoryId: room.CategoryId, Description: &description}
+		resp := doRequest(t, handler.HandleUpdateCategory, "POST", "/api/categories/update", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var updated types.Category
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&updated))
+		assert.Equal(t, "old room category", updated.Name)
+		assert.Equal(t, description, updated.Description)
+	}
+}
+
+func TestArchiveAndDeleteRoom(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "waitlist_entries"))
+	config.Config.AdminPermissionIdx = 100
+	room := addRoomForTest(t, "old room")
+
+	start := time.Now().Add(24 * time.Hour).Unix()
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: start,
+		EndTimestamp:   start + 1000,
+		Repeats:        1,
+	}
+	resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	joinReq := types.JoinWaitlistReq{RoomId: room.Id, Reservee: "waiter", Email: "waiter@foo.com", PhoneNumber: "010", Reason: "bacchus", StartTimestamp: start, EndTimestamp: start + 1000}
+	resp = doRequest(t, handler.HandleJoinWaitlist, "POST", "/api/waitlist/join", joinReq, 2, 1)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	{
+		body := types.ArchiveRoomReq{RoomId: room.Id, Archived: true}
+		resp := doRequest(t, handler.HandleArchiveRoom, "POST", "/api/rooms/archive", body, 1, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = doRequest(t, handler.HandleArchiveRoom, "POST", "/api/rooms/archive", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// nobody is waiting for an archived room
+		resp = doRequest(t, handler.HandleGetMyWaitlistEntries, "GET", "/api/waitlist/mine", nil, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var entriesResp types.GetMyWaitlistEntriesResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&entriesResp))
+		require.Len(t, entriesResp.Entries, 1)
+		assert.Equal(t, types.WaitlistStatusExpired, entriesResp.Entries[0].Status)
+	}
+	{
+		// archived rooms cannot be booked and are hidden
+		addReq.StartTimestamp += 2000
+		addReq.EndTimestamp += 2000
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleGetRoomsAndCategories, "GET", "/api/rooms/get", nil, 1, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var roomsResp types.GetRoomsAndCategoriesResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&roomsResp))
+		assert.Len(t, roomsResp.Rooms, 0)
+
+		resp = doRequest(t, handler.HandleGetRoomsAndCategories, "GET", "/api/rooms/get", nil, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&roomsResp))
+		require.Len(t, roomsResp.Rooms, 1)
+		assert.True(t, roomsResp.Rooms[0].Archived)
+	}
+	{
+		// the history is kept
+		var schedules []*types.Schedule
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			schedules, err = tx.GetSchedules(room.Id, start, start+10000)
+			return err
+		}))
+		assert.Len(t, schedules, 1)
+	}
+	{
+		// upcoming bookings are listed instead of deleted
+		body := types.DeleteRoomReq{RoomId: room.Id}
+		resp := doRequest(t, handler.HandleDeleteRoom, "POST", "/api/rooms/delete", body, 1, 100)
+		require.Equal(t, http.StatusConflict, resp.StatusCode)
+		var deleteResp types.DeleteRoomResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&deleteResp))
+		require.Len(t, deleteResp.AffectedGroups, 1)
+		assert.Equal(t, "doge", deleteResp.AffectedGroups[0].Reservee)
+
+		body.Force = true
+		resp = doRequest(t, handler.HandleDeleteRoom, "POST", "/api/rooms/delete", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&deleteResp))
+		assert.Len(t, deleteResp.AffectedGroups, 1)
+
+		err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			_, err := tx.GetRoomById(room.Id)
+			return err
+		})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRoleGrants(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "role_grants"))
+	config.Config.AdminPermissionIdx = 100
+	config.Config.DefaultRole = types.RoleViewer
+	defer func() { config.Config.DefaultRole = types.RoleBooker }()
+	room := addRoomForTest(t, "granted room")
+	other := addRoomForTest(t, "other room")
+
+	addReq := func(roomId int64, start int64) types.AddScheduleReq {
+		return types.AddScheduleReq{
+			RoomId:         roomId,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: start,
+			EndTimestamp:   start + 1000,
+			Repeats:        1,
+		}
+	}
+	grant := func(body types.AddRoleGrantReq) {
+		resp := doRequest(t, handler.HandleAddRoleGrant, "POST", "/api/grants/add", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	{
+		// viewers cannot book
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 10000), 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		// only admins grant roles
+		body := types.AddRoleGrantReq{UserIdx: 2, Role: types.RoleBooker, RoomId: room.Id, CategoryId: -1}
+		resp = doRequest(t, handler.HandleAddRoleGrant, "POST", "/api/grants/add", body, 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		body.Role = "owner"
+		resp = doRequest(t, handler.HandleAddRoleGrant, "POST", "/api/grants/add", body, 1, 100)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// booker in a single room
+		grant(types.AddRoleGrantReq{UserIdx: 2, Role: types.RoleBooker, RoomId: room.Id, CategoryId: -1})
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 10000), 2, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(other.Id, 10000), 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	var schedules []*types.Schedule
+	require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		var err error
+		schedules, err = tx.GetSchedules(room.Id, 0, 20000)
+		return err
+	}))
+	require.Len(t, schedules, 1)
+	{
+		// room managers of the category cancel bookings of others
+		body := types.DeleteScheduleReq{ScheduleId: schedules[0].Id}
+		resp := doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		grant(types.AddRoleGrantReq{UserIdx: 3, Role: types.RoleRoomManager, RoomId: -1, CategoryId: other.CategoryId})
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 3, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		grant(types.AddRoleGrantReq{UserIdx: 3, Role: types.RoleRoomManager, RoomId: -1, CategoryId: room.CategoryId})
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 3, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// roles from the token
+		payload := &handler.JWTPayload{
+			Issuer:        config.Config.JWTIssuer,
+			Audience:      config.Config.JWTAudience,
+			Expire:        time.Now().Add(time.Second * 100).Unix(),
+			UserIdx:       4,
+			Username:      "foo",
+			PermissionIdx: 1,
+			Roles:         []string{types.RoleAdmin},
+		}
+		token, err := generateTokenWithPayload(payload)
+		require.Nil(t, err)
+		req := httptest.NewRequest("GET", "/api/grants/get", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		w := httptest.NewRecorder()
+		handler.HandleGetRoleGrants(w, req)
+		resp := w.Result()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var grantsResp types.GetRoleGrantsResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&grantsResp))
+		require.Len(t, grantsResp.Grants, 3)
+
+		body := types.DeleteRoleGrantReq{GrantId: grantsResp.Grants[0].Id}
+		resp = doRequest(t, handler.HandleDeleteRoleGrant, "POST", "/api/grants/delete", body, 1, 100)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq(room.Id, 20000), 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestRoomManagers(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "role_grants", "booking_policies"))
+	config.Config.AdminPermissionIdx = 100
+	managed := &types.Room{Name: "managed room", Seats: 10, RequiresApproval: true}
+	other := &types.Room{Name: "other room", Seats: 10, RequiresApproval: true}
+	require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		for _, room := range []*types.Room{managed, other} {
+			category := &types.Category{Name: room.Name + " category"}
+			if err := tx.AddCategory(category); err != nil {
+				return err
+			}
+			room.CategoryId = category.Id
+			if err := tx.AddRoom(room); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	const managerIdx = 3
+	manager := types.RoomManagerReq{UserIdx: managerIdx, RoomId: managed.Id, CategoryId: -1}
+	{
+		resp := doRequest(t, handler.HandleAssignRoomManager, "POST", "/api/managers/assign", manager, managerIdx, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		body := types.RoomManagerReq{UserIdx: managerIdx, RoomId: -1, CategoryId: -1}
+		resp = doRequest(t, handler.HandleAssignRoomManager, "POST", "/api/managers/assign", body, 1, 100)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleAssignRoomManager, "POST", "/api/managers/assign", manager, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleAssignRoomManager, "POST", "/api/managers/assign", manager, 1, 100)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleGetRoomManagers, "GET", "/api/managers/get", nil, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var managersResp types.GetRoomManagersResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&managersResp))
+		require.Len(t, managersResp.Managers, 1)
+		assert.Equal(t, int64(managerIdx), managersResp.Managers[0].UserIdx)
+		assert.Equal(t, managed.Id, managersResp.Managers[0].RoomId)
+	}
+
+	groupIds := map[int64]int64{}
+	for _, room := range []*types.Room{managed, other} {
+		addReq := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 10000,
+			EndTimestamp:   11000,
+			Repeats:        1,
+		}
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var addResp types.AddScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&addResp))
+		groupIds[room.Id] = addResp.ScheduleGroupId
+	}
+	{
+		// managers only see and review bookings of their rooms
+		resp := doRequest(t, handler.HandleGetPendingScheduleGroups, "GET", "/api/schedule/pending/get", nil, managerIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var pendingResp types.GetPendingScheduleGroupsResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&pendingResp))
+		require.Len(t, pendingResp.Groups, 1)
+		assert.Equal(t, groupIds[managed.Id], pendingResp.Groups[0].Id)
+
+		body := types.ApproveScheduleGroupReq{ScheduleGroupId: groupIds[other.Id]}
+		resp = doRequest(t, handler.HandleApproveScheduleGroup, "POST", "/api/schedule/approve", body, managerIdx, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		body = types.ApproveScheduleGroupReq{ScheduleGroupId: groupIds[managed.Id]}
+		resp = doRequest(t, handler.HandleApproveScheduleGroup, "POST", "/api/schedule/approve", body, managerIdx, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	{
+		// managers change policies of their rooms, but not of the category
+		body := types.AddBookingPolicyReq{RoomId: managed.Id, CategoryId: -1, MaxDuration: 3600}
+		resp := doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, managerIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var policy types.BookingPolicy
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&policy))
+
+		body = types.AddBookingPolicyReq{RoomId: other.Id, CategoryId: -1, MaxDuration: 3600}
+		resp = doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, managerIdx, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		body = types.AddBookingPolicyReq{RoomId: -1, CategoryId: managed.CategoryId, MaxDuration: 3600}
+		resp = doRequest(t, handler.HandleAddBookingPolicy, "POST", "/api/policies/add", body, managerIdx, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		update := types.UpdateBookingPolicyReq{PolicyId: policy.Id, MaxDuration: 7200}
+		resp = doRequest(t, handler.HandleUpdateBookingPolicy, "POST", "/api/policies/update", update, managerIdx, 1)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleUpdateBookingPolicy, "POST", "/api/policies/update", update, 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		resp := doRequest(t, handler.HandleRevokeRoomManager, "POST", "/api/managers/revoke", manager, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = doRequest(t, handler.HandleRevokeRoomManager, "POST", "/api/managers/revoke", manager, 1, 100)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = doRequest(t, handler.HandleGetPendingScheduleGroups, "GET", "/api/schedule/pending/get", nil, managerIdx, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "api_tokens"))
+	room := addRoomForTest(t, "scripted room")
+	const userIdx = 7
+
+	issue := func(scope string, expiresIn int64) *http.Response {
+		body := types.IssueAPITokenReq{Name: "lab class", Scope: scope, ExpiresIn: expiresIn}
+		return doRequest(t, handler.HandleIssueAPIToken, "POST", "/api/tokens/issue", body, userIdx, 1)
+	}
+	withToken := func(f http.HandlerFunc, method string, target string, body interface{}, token string) *http.Response {
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			require.Nil(t, err)
+			reader = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		w := httptest.NewRecorder()
+		f(w, req)
+		return w.Result()
+	}
+	addReq := types.AddScheduleReq{
+		RoomId:         room.Id,
+		Reservee:       "doge",
+		Email:          "doge@foo.com",
+		PhoneNumber:    "010",
+		Reason:         "bacchus",
+		StartTimestamp: 10000,
+		EndTimestamp:   11000,
+		Repeats:        1,
+	}
+
+	{
+		resp := issue("write", 3600)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = issue(types.APITokenScopeRead, 0)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		resp = issue(types.APITokenScopeRead, int64(config.Config.APITokenMaxLifetime/time.Second)+1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	var readToken, bookToken types.IssueAPITokenResp
+	{
+		resp := issue(types.APITokenScopeRead, 3600)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&readToken))
+		resp = issue(types.APITokenScopeBook, 3600)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&bookToken))
+		assert.Equal(t, int64(-1), bookToken.LastUsedTimestamp)
+	}
+	{
+		// read-only tokens cannot book
+		resp := withToken(handler.HandleGetMyScheduleGroups, "GET", "/api/schedule/mine", nil, readToken.Token)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = withToken(handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, readToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		resp = withToken(handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, bookToken.Token)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var addResp types.AddScheduleResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&addResp))
+
+		// bookings are owned by the owner of the token
+		var group *types.ScheduleGroup
+		require.Nil(t, sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+			var err error
+			group, err = tx.GetScheduleGroupById(addResp.ScheduleGroupId)
+			return err
+		}))
+		assert.Equal(t, int64(userIdx), group.UserIdx)
+
+		// read-only tokens can see their own bookings but not change them
+		target := fmt.Sprintf("/api/schedule/info/get?scheduleGroupId=%d", group.Id)
+		resp = withToken(handler.HandleGetScheduleInfo, "GET", target, nil, readToken.Token)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		info := types.UpdateScheduleInfoReq{ScheduleGroupId: group.Id, Reservee: "doge", Email: "doge@foo.com", PhoneNumber: "010", Reason: "scripted"}
+		resp = withToken(handler.HandleUpdateScheduleInfo, "POST", "/api/schedule/info/update", info, readToken.Token)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+	{
+		// tokens cannot manage tokens
+		body := types.IssueAPITokenReq{Name: "nested", Scope: types.APITokenScopeAdmin, ExpiresIn: 3600}
+		resp := withToken(handler.HandleIssueAPIToken, "POST", "/api/tokens/issue", body, bookToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = withToken(handler.HandleGetAPITokens, "GET", "/api/tokens/get", nil, readToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		revoke := types.RevokeAPITokenReq{TokenId: bookToken.Id}
+		resp = withToken(handler.HandleRevokeAPIToken, "POST", "/api/tokens/revoke", revoke, readToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		// admin tokens do not keep the admin permission of the jwt
+		config.Config.AdminPermissionIdx = 100
+		body := types.IssueAPITokenReq{Name: "admin", Scope: types.APITokenScopeAdmin, ExpiresIn: 3600}
+		resp := doRequest(t, handler.HandleIssueAPIToken, "POST", "/api/tokens/issue", body, userIdx, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var adminToken types.IssueAPITokenResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&adminToken))
+		resp = withToken(handler.HandleGetRoleGrants, "GET", "/api/grants/get", nil, adminToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	{
+		resp := doRequest(t, handler.HandleGetAPITokens, "GET", "/api/tokens/get", nil, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var tokensResp types.GetAPITokensResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&tokensResp))
+		require.Len(t, tokensResp.Tokens, 3)
+		assert.Equal(t, bookToken.Id, tokensResp.Tokens[1].Id)
+		assert.NotEqual(t, int64(-1), tokensResp.Tokens[1].LastUsedTimestamp)
+		assert.False(t, tokensResp.Tokens[1].Revoked)
+	}
+	{
+		// tokens of others cannot be revoked
+		body := types.RevokeAPITokenReq{TokenId: bookToken.Id}
+		resp := doRequest(t, handler.HandleRevokeAPIToken, "POST", "/api/tokens/revoke", body, userIdx+1, 1)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = doRequest(t, handler.HandleRevokeAPIToken, "POST", "/api/tokens/revoke", body, userIdx, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp = withToken(handler.HandleGetMyScheduleGroups, "GET", "/api/schedule/mine", nil, bookToken.Token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	require.Nil(t, sql.TruncateForTest("categories", "rooms", "schedule_groups", "schedules", "audit_log"))
+	config.Config.AdminPermissionIdx = 100
+	category := addRoomForTest(t, "audited room").CategoryId
+
+	getAuditLog := func(query string) []*types.AuditLogEntry {
+		resp := doRequest(t, handler.HandleGetAuditLog, "GET", "/api/audit/get?"+query, nil, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var auditResp types.GetAuditLogResp
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&auditResp))
+		return auditResp.Entries
+	}
+
+	var room types.Room
+	{
+		body := types.AddRoomReq{Name: "new room", Seats: 4, CategoryId: category}
+		b, err := json.Marshal(body)
+		require.Nil(t, err)
+		req := httptest.NewRequest("POST", "/api/rooms/add", bytes.NewReader(b))
+		setJWTToken(t, req, 1, "doge", 100)
+		req.Header.Set(handler.RequestIdHeader, "forged")
+		w := httptest.NewRecorder()
+		handler.HandleAddRoom(w, handler.WithRequestId(req, "add-room"))
+		resp := w.Result()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// ids sent by clients are not recorded
+		assert.Len(t, getAuditLog("requestId=forged"), 0)
+		entries := getAuditLog("requestId=add-room")
+		require.Len(t, entries, 1)
+		assert.Equal(t, "add", entries[0].Action)
+		assert.Equal(t, "rooms", entries[0].Entity)
+		room.Id = entries[0].EntityId
+		assert.Equal(t, int64(1), entries[0].UserIdx)
+		assert.Equal(t, "doge", entries[0].Username)
+		assert.Equal(t, "null", string(entries[0].Before))
+		assert.Contains(t, string(entries[0].After), `"name": "new room"`)
+	}
+	{
+		addReq := types.AddScheduleReq{
+			RoomId:         room.Id,
+			Reservee:       "doge",
+			Email:          "doge@foo.com",
+			PhoneNumber:    "010",
+			Reason:         "bacchus",
+			StartTimestamp: 10000,
+			EndTimestamp:   11000,
+			Repeats:        1,
+		}
+		resp := doRequest(t, handler.HandleAddSchedule, "POST", "/api/schedule/add", addReq, 2, 1)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		entries := getAuditLog("userIdx=2&entity=schedules")
+		require.Len(t, entries, 1)
+		scheduleId := entries[0].EntityId
+
+		// admins deleting bookings of others leave a trace
+		body := types.DeleteScheduleReq{ScheduleId: scheduleId}
+		resp = doRequest(t, handler.HandleDeleteSchedule, "POST", "/api/schedule/delete", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		entries = getAuditLog(fmt.Sprintf("entity=schedules&entityId=%d&action=delete", scheduleId))
+		require.Len(t, entries, 1)
+		assert.Equal(t, int64(1), entries[0].UserIdx)
+		assert.NotEqual(t, "null", string(entries[0].Before))
+		assert.Equal(t, "null", string(entries[0].After))
+	}
+	{
+		// kiosk tokens are not logged
+		body := types.IssueKioskTokenReq{RoomId: room.Id}
+		resp := doRequest(t, handler.HandleIssueKioskToken, "POST", "/api/rooms/kiosk/issue", body, 1, 100)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, getAuditLog(fmt.Sprintf("entity=rooms&entityId=%d&action=update", room.Id)), 0)
+	}
+	{
+		resp := doRequest(t, handler.HandleGetAuditLog, "GET", "/api/audit/get", nil, 2, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = doRequest(t, handler.HandleGetAuditLog, "GET", "/api/audit/get?entityId=foo", nil, 1, 100)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
 }
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/notify"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HandleHoldSchedule reserves a time range for a short while, until it is
+// confirmed with the details of the booking or expires. Others see the held
+// schedule as occupied.
+func HandleHoldSchedule(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.HoldScheduleReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+
+	var resp types.HoldScheduleResp
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		rangesOfRoom := map[int64][]*types.TimeRange{
+			req.RoomId: {{StartTimestamp: req.StartTimestamp, EndTimestamp: req.EndTimestamp}},
+		}
+		if err := checkNewSchedules(tx, p, rangesOfRoom, config.Config.DefaultLocation); err != nil {
+			return err
+		}
+
+		g := &types.ScheduleGroup{
+			RoomId:        req.RoomId,
+			UserIdx:       int64(p.UserIdx),
+			Reservee:      p.Username,
+			Timezone:      config.Config.DefaultLocation.String(),
+			Status:        types.ScheduleGroupStatusHeld,
+			HoldExpiresAt: time.Now().Add(config.Config.HoldTTL).Unix(),
+		}
+		if err := tx.AddScheduleGroup(g); err != nil {
+			return err
+		}
+		s := &types.Schedule{
+			RoomId:          req.RoomId,
+			ScheduleGroupId: g.Id,
+			StartTimestamp:  req.StartTimestamp,
+			EndTimestamp:    req.EndTimestamp,
+			Seats:           req.Seats,
+			Seat:            req.Seat,
+		}
+		if err := tx.AddSchedule(s); err != nil {
+			return err
+		}
+		resp.ScheduleGroupId = g.Id
+		resp.ScheduleId = s.Id
+		resp.ExpiresAt = g.HoldExpiresAt
+		return nil
+	})
+	var (
+		conflictErr *sql.ConflictError
+		policyErr   *policyViolationError
+	)
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if errors.As(err, &policyErr) {
+		httpError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	} else if errors.As(err, &conflictErr) {
+		conflictError(w, "schedule conflicts with other schedules", conflictErr)
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to hold schedule", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+// HandleConfirmHold turns a hold of the user into a booking with the given
+// details.
+func HandleConfirmHold(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.ConfirmHoldReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.Reservee == "" || req.Email == "" || req.PhoneNumber == "" || req.Reason == "" {
+		httpError(w, http.StatusBadRequest, "reservee, email, phone number and reason are required")
+		return
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		g, err := tx.GetScheduleGroupById(req.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if !canModifyAsOwner(p, g.UserIdx) {
+			return errors.New("you are not the owner of hold")
+		}
+		if g.Status != types.ScheduleGroupStatusHeld {
+			return errors.New("schedule group is not held")
+		}
+		room, err := tx.GetRoomById(g.RoomId)
+		if err != nil {
+			return err
+		}
+
+		g.Reservee = req.Reservee
+		g.Email = req.Email
+		g.PhoneNumber = req.PhoneNumber
+		g.Reason = req.Reason
+		if err := tx.UpdateScheduleGroup(g); err != nil {
+			return err
+		}
+		override, err := can(tx, p, capabilityOverridePolicies, room.Id)
+		if err != nil {
+			return err
+		}
+		status := types.ScheduleGroupStatusApproved
+		if room.RequiresApproval && !override {
+			status = types.ScheduleGroupStatusPending
+		}
+		if err := tx.ConfirmHeldScheduleGroup(g.Id, status); errors.Is(err, sql.ErrNoRowAffected) {
+			return errHoldExpired
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, errHoldExpired) {
+		httpError(w, http.StatusGone, "hold has expired")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to confirm hold", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+// HandleReleaseHold gives up a hold of the user before it expires.
+func HandleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.ReleaseHoldReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		g, err := tx.GetScheduleGroupById(req.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if !canModifyAsOwner(p, g.UserIdx) {
+			return errors.New("you are not the owner of hold")
+		}
+		if g.Status != types.ScheduleGroupStatusHeld {
+			return errors.New("schedule group is not held")
+		}
+		schedules, err := tx.GetSchedulesInGroup(g.Id, 0)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteScheduleGroup(g.Id); err != nil {
+			return err
+		}
+
+		ranges := make([]*types.TimeRange, 0, len(schedules))
+		for _, s := range schedules {
+			ranges = append(ranges, &types.TimeRange{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp})
+		}
+		notifications, err = promoteWaiters(tx, g.RoomId, ranges)
+		return err
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to release hold", err)
+		return
+	}
+	notify.Send(notifications...)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
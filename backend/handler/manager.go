@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// readRoomManagerReq reads the body of the assign and revoke requests, and
+// writes an error response if it cannot be read or names neither or both a
+// room and a category.
+func readRoomManagerReq(w http.ResponseWriter, r *http.Request) (*types.RoomManagerReq, bool) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return nil, false
+	}
+
+	var req types.RoomManagerReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return nil, false
+	}
+	if (req.RoomId == -1) == (req.CategoryId == -1) {
+		httpError(w, http.StatusBadRequest, "either room or category should be given")
+		return nil, false
+	}
+	return &req, true
+}
+
+func HandleGetRoomManagers(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	var resp types.GetRoomManagersResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		managers, err := tx.GetRoleGrantsWithRole(types.RoleRoomManager)
+		if err != nil {
+			return err
+		}
+		resp.Managers = managers
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get room managers", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+// HandleAssignRoomManager grants the room manager role in a room or category.
+func HandleAssignRoomManager(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	req, ok := readRoomManagerReq(w, r)
+	if !ok {
+		return
+	}
+
+	grant := &types.RoleGrant{
+		UserIdx:    req.UserIdx,
+		Role:       types.RoleRoomManager,
+		RoomId:     req.RoomId,
+		CategoryId: req.CategoryId,
+	}
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.AddRoleGrant(grant)
+	})
+	if errors.Is(err, sql.ErrDuplicateGrant) {
+		httpError(w, http.StatusConflict, "already a manager")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to assign room manager", err)
+		return
+	}
+
+	if b, err := json.Marshal(grant); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleRevokeRoomManager(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	req, ok := readRoomManagerReq(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.DeleteRoleGrantOf(req.UserIdx, types.RoleRoomManager, req.RoomId, req.CategoryId)
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to revoke room manager", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+func HandleGetMyScheduleGroups(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityView) {
+		return
+	}
+
+	req := types.GetMyScheduleGroupsReq{
+		RoomId:   -1,
+		When:     "all",
+		PageSize: config.Config.PageSizeLimit,
+	}
+	qs := r.URL.Query()
+	if qs.Get("roomId") != "" {
+		rid, err := strconv.ParseInt(qs.Get("roomId"), 10, 64)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.RoomId = rid
+	}
+	if qs.Get("when") != "" {
+		req.When = qs.Get("when")
+	}
+	if qs.Get("page") != "" {
+		page, err := strconv.Atoi(qs.Get("page"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.Page = page
+	}
+	if qs.Get("pageSize") != "" {
+		pageSize, err := strconv.Atoi(qs.Get("pageSize"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "cannot parse query value", err)
+			return
+		}
+		req.PageSize = pageSize
+	}
+
+	if req.When != "all" && req.When != "upcoming" && req.When != "past" {
+		httpError(w, http.StatusBadRequest, "invalid when")
+		return
+	}
+	if req.Page < 0 {
+		httpError(w, http.StatusBadRequest, "page is less than 0")
+		return
+	}
+	if req.PageSize <= 0 {
+		httpError(w, http.StatusBadRequest, "page size is less than 1")
+		return
+	}
+	if config.Config.PageSizeLimit < req.PageSize {
+		httpError(w, http.StatusBadRequest, "page size is too large")
+		return
+	}
+
+	var resp types.GetMyScheduleGroupsResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		groups, err := tx.GetScheduleGroupsOfUser(int64(p.UserIdx), req.RoomId, req.When, req.PageSize, req.Page*req.PageSize)
+		if err != nil {
+			return err
+		}
+		groupIds := make([]int64, 0, len(groups))
+		byId := map[int64]*types.MyScheduleGroup{}
+		for _, g := range groups {
+			groupIds = append(groupIds, g.Id)
+			byId[g.Id] = g
+		}
+		upcoming, err := tx.GetUpcomingSchedulesInGroups(groupIds)
+		if err != nil {
+			return err
+		}
+		for _, s := range upcoming {
+			g := byId[s.ScheduleGroupId]
+			if g.NextOccurrence == nil {
+				g.NextOccurrence = &types.TimeRange{
+					StartTimestamp: s.StartTimestamp,
+					EndTimestamp:   s.EndTimestamp,
+				}
+			}
+			g.UpcomingSchedules = append(g.UpcomingSchedules, s)
+		}
+		resp.Groups = groups
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get schedule groups", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// capability is a named action handlers check instead of roles, so that roles
+// can be changed without touching every handler.
+type capability string
+
+const (
+	// see schedules and own bookings
+	capabilityView capability = "view"
+	// book rooms, hold slots and join waitlists
+	capabilityBook capability = "book"
+	// book without booking policies, quotas and approval
+	capabilityOverridePolicies capability = "override_policies"
+	// approve, cancel and edit bookings of other users
+	capabilityManageBookings capability = "manage_bookings"
+	// change policies, quotas, opening hours, closures and kiosks of rooms
+	capabilityConfigureRooms capability = "configure_rooms"
+	// add, change, archive and delete rooms and categories
+	capabilityManageRooms capability = "manage_rooms"
+	// grant and revoke roles
+	capabilityManageGrants capability = "manage_grants"
+	// see who changed what
+	capabilityViewAuditLog capability = "view_audit_log"
+)
+
+var roleCapabilities = map[string][]capability{
+	types.RoleViewer: {capabilityView},
+	types.RoleBooker: {capabilityView, capabilityBook},
+	types.RoleRoomManager: {
+		capabilityView, capabilityBook, capabilityOverridePolicies,
+		capabilityManageBookings, capabilityConfigureRooms,
+	},
+	types.RoleAdmin: {
+		capabilityView, capabilityBook, capabilityOverridePolicies,
+		capabilityManageBookings, capabilityConfigureRooms,
+		capabilityManageRooms, capabilityManageGrants, capabilityViewAuditLog,
+	},
+}
+
+var errPermissionDenied = errors.New("permission denied")
+
+func isValidRole(role string) bool {
+	_, ok := roleCapabilities[role]
+	return ok
+}
+
+func hasCapability(roles []string, c capability) bool {
+	for _, role := range roles {
+		for _, rc := range roleCapabilities[role] {
+			if rc == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimRoles returns the global roles of the token: the default role of every
+// user, and for jwts the roles claim and admin for the admin permission. API
+// tokens outlive the jwts they were issued with, so they have no claims.
+func claimRoles(p *JWTPayload) []string {
+	roles := []string{}
+	if config.Config.DefaultRole != "" {
+		roles = append(roles, config.Config.DefaultRole)
+	}
+	if p.TokenId != 0 {
+		return roles
+	}
+	roles = append(roles, p.Roles...)
+	if config.Config.AdminPermissionIdx == p.PermissionIdx {
+		roles = append(roles, types.RoleAdmin)
+	}
+	return roles
+}
+
+// can reports whether the user has the capability in the room, either from
+// the token or from grants in the room, its category or globally. roomId -1
+// only considers global grants.
+func can(tx *sql.Tx, p *JWTPayload, c capability, roomId int64) (bool, error) {
+	return canIn(tx, p, c, roomId, -1)
+}
+
+// canIn is can for things which belong to either a room or a category, such as
+// policies and closures. Grants in a single room never apply to its category.
+func canIn(tx *sql.Tx, p *JWTPayload, c capability, roomId int64, categoryId int64) (bool, error) {
+	if !scopeAllows(p, c) {
+		return false, nil
+	}
+	if hasCapability(claimRoles(p), c) {
+		return true, nil
+	}
+	roles, err := tx.GetRolesOfUser(int64(p.UserIdx), roomId, categoryId)
+	if err != nil {
+		return false, err
+	}
+	return hasCapability(roles, c), nil
+}
+
+// checkCapabilityIn is canIn which returns errPermissionDenied if the user
+// lacks the capability.
+func checkCapabilityIn(tx *sql.Tx, p *JWTPayload, c capability, roomId int64, categoryId int64) error {
+	if ok, err := canIn(tx, p, c, roomId, categoryId); err != nil {
+		return err
+	} else if !ok {
+		return errPermissionDenied
+	}
+	return nil
+}
+
+// canAnywhere reports whether the user has the capability in at least one
+// room, which is enough to see lists filtered to where the user has it.
+func canAnywhere(tx *sql.Tx, p *JWTPayload, c capability) (bool, error) {
+	if !scopeAllows(p, c) {
+		return false, nil
+	}
+	if hasCapability(claimRoles(p), c) {
+		return true, nil
+	}
+	grants, err := tx.GetRoleGrantsOfUser(int64(p.UserIdx))
+	if err != nil {
+		return false, err
+	}
+	for _, g := range grants {
+		if hasCapability([]string{g.Role}, c) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// canInScheduleGroup reports whether the user has the capability in every
+// room of the group.
+func canInScheduleGroup(tx *sql.Tx, p *JWTPayload, c capability, groupId int64) (bool, error) {
+	if !scopeAllows(p, c) {
+		return false, nil
+	}
+	if hasCapability(claimRoles(p), c) {
+		return true, nil
+	}
+	roomIds, err := tx.GetRoomIdsOfScheduleGroup(groupId)
+	if err != nil {
+		return false, err
+	}
+	for _, roomId := range roomIds {
+		if ok, err := can(tx, p, c, roomId); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// canViewAsOwner reports whether the user owns something, such as a booking,
+// and may see it with the token the request was made with.
+func canViewAsOwner(p *JWTPayload, userIdx int64) bool {
+	return userIdx == int64(p.UserIdx) && scopeAllows(p, capabilityView)
+}
+
+// canModifyAsOwner reports whether the user owns something, such as a
+// booking, and may change it with the token the request was made with.
+func canModifyAsOwner(p *JWTPayload, userIdx int64) bool {
+	return userIdx == int64(p.UserIdx) && scopeAllows(p, capabilityBook)
+}
+
+// canViewScheduleGroup reports whether the user owns the group, or can manage
+// bookings in every room of the group.
+func canViewScheduleGroup(tx *sql.Tx, p *JWTPayload, g *types.ScheduleGroup) (bool, error) {
+	if canViewAsOwner(p, g.UserIdx) {
+		return true, nil
+	}
+	return canInScheduleGroup(tx, p, capabilityManageBookings, g.Id)
+}
+
+// canManageScheduleGroup reports whether the user owns the group and may
+// change it, or can manage bookings in every room of the group.
+func canManageScheduleGroup(tx *sql.Tx, p *JWTPayload, g *types.ScheduleGroup) (bool, error) {
+	if canModifyAsOwner(p, g.UserIdx) {
+		return true, nil
+	}
+	return canInScheduleGroup(tx, p, capabilityManageBookings, g.Id)
+}
+
+// requireCapability checks that the user has the capability globally, and
+// writes an error response if not.
+func requireCapability(w http.ResponseWriter, p *JWTPayload, c capability) bool {
+	if scopeAllows(p, c) && hasCapability(claimRoles(p), c) {
+		return true
+	}
+	var ok bool
+	err := sql.WithTx(context.Background(), func(tx *sql.Tx) error {
+		var err error
+		ok, err = can(tx, p, c, -1)
+		return err
+	})
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to check permission", err)
+		return false
+	}
+	if !ok {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return false
+	}
+	return true
+}
+
+func HandleGetRoleGrants(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	var resp types.GetRoleGrantsResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		grants, err := tx.GetAllRoleGrants()
+		if err != nil {
+			return err
+		}
+		resp.Grants = grants
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get role grants", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleAddRoleGrant(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.AddRoleGrantReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+	if !isValidRole(req.Role) {
+		httpError(w, http.StatusBadRequest, "invalid role")
+		return
+	}
+
+	grant := &types.RoleGrant{
+		UserIdx:    req.UserIdx,
+		Role:       req.Role,
+		RoomId:     req.RoomId,
+		CategoryId: req.CategoryId,
+	}
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.AddRoleGrant(grant)
+	})
+	if errors.Is(err, sql.ErrDuplicateGrant) {
+		httpError(w, http.StatusConflict, "role already granted")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to add role grant", err)
+		return
+	}
+
+	if b, err := json.Marshal(grant); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleDeleteRoleGrant(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityManageGrants) {
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.DeleteRoleGrantReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		return tx.DeleteRoleGrant(req.GrantId)
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to delete role grant", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// policyViolationError carries a message which is shown to the user as is.
+type policyViolationError struct {
+	msg string
+}
+
+func (e *policyViolationError) Error() string {
+	return e.msg
+}
+
+func policyViolation(format string, args ...interface{}) error {
+	return &policyViolationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// checkBookingPolicy checks a schedule against the policy at the given time.
+// Slot granularity is applied to the wall clock time of loc.
+func checkBookingPolicy(policy *types.BookingPolicy, startTimestamp int64, endTimestamp int64, now time.Time, loc *time.Location) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.MaxDuration > 0 && endTimestamp-startTimestamp > policy.MaxDuration {
+		return policyViolation("booking cannot be longer than %s", time.Duration(policy.MaxDuration)*time.Second)
+	}
+	start := time.Unix(startTimestamp, 0)
+	if policy.MaxDaysInAdvance > 0 && start.After(now.AddDate(0, 0, policy.MaxDaysInAdvance)) {
+		return policyViolation("booking cannot start more than %d days in advance", policy.MaxDaysInAdvance)
+	}
+	if policy.MinNotice > 0 && start.Before(now.Add(time.Duration(policy.MinNotice)*time.Second)) {
+		return policyViolation("booking must be made at least %s in advance", time.Duration(policy.MinNotice)*time.Second)
+	}
+	if g := policy.SlotGranularity; g > 0 {
+		startOffset := int64(secondOfDay(start.In(loc)))
+		endOffset := int64(secondOfDay(time.Unix(endTimestamp, 0).In(loc)))
+		if startOffset%g != 0 || endOffset%g != 0 {
+			return policyViolation("booking must be aligned to %s slots", time.Duration(g)*time.Second)
+		}
+	}
+	return nil
+}
+
+func HandleGetBookingPolicies(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	var resp types.GetBookingPoliciesResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := canAnywhere(tx, p, capabilityConfigureRooms); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		policies, err := tx.GetAllBookingPolicies()
+		if err != nil {
+			return err
+		}
+		resp.Policies = []*types.BookingPolicy{}
+		for _, policy := range policies {
+			if ok, err := canIn(tx, p, capabilityConfigureRooms, policy.RoomId, policy.CategoryId); err != nil {
+				return err
+			} else if ok {
+				resp.Policies = append(resp.Policies, policy)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get booking policies", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleAddBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.AddBookingPolicyReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	policy := &types.BookingPolicy{
+		RoomId:           req.RoomId,
+		CategoryId:       req.CategoryId,
+		MaxDuration:      req.MaxDuration,
+		MaxDaysInAdvance: req.MaxDaysInAdvance,
+		MinNotice:        req.MinNotice,
+		SlotGranularity:  req.SlotGranularity,
+	}
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, policy.RoomId, policy.CategoryId); err != nil {
+			return err
+		}
+		return tx.AddBookingPolicy(policy)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to add booking policy", err)
+		return
+	}
+
+	if b, err := json.Marshal(policy); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleUpdateBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.UpdateBookingPolicyReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		old, err := tx.GetBookingPolicyById(req.PolicyId)
+		if err != nil {
+			return err
+		}
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, old.RoomId, old.CategoryId); err != nil {
+			return err
+		}
+		policy := &types.BookingPolicy{
+			Id:               req.PolicyId,
+			MaxDuration:      req.MaxDuration,
+			MaxDaysInAdvance: req.MaxDaysInAdvance,
+			MinNotice:        req.MinNotice,
+			SlotGranularity:  req.SlotGranularity,
+		}
+		return tx.UpdateBookingPolicy(policy)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update booking policy", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+func HandleDeleteBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.DeleteBookingPolicyReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		policy, err := tx.GetBookingPolicyById(req.PolicyId)
+		if err != nil {
+			return err
+		}
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, policy.RoomId, policy.CategoryId); err != nil {
+			return err
+		}
+		return tx.DeleteBookingPolicy(req.PolicyId)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to delete booking policy", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
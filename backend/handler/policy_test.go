@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBookingPolicy(t *testing.T) {
+	now := time.Date(2022, 3, 1, 9, 0, 0, 0, time.UTC)
+	start := now.Add(24 * time.Hour).Unix()
+	hour := int64(60 * 60)
+
+	assert.Nil(t, checkBookingPolicy(nil, start, start+100*hour, now, time.UTC))
+	{
+		policy := &types.BookingPolicy{MaxDuration: 2 * hour}
+		assert.Nil(t, checkBookingPolicy(policy, start, start+2*hour, now, time.UTC))
+		assert.NotNil(t, checkBookingPolicy(policy, start, start+2*hour+1, now, time.UTC))
+	}
+	{
+		policy := &types.BookingPolicy{MaxDaysInAdvance: 7}
+		assert.Nil(t, checkBookingPolicy(policy, start, start+hour, now, time.UTC))
+		farStart := now.AddDate(0, 0, 8).Unix()
+		assert.NotNil(t, checkBookingPolicy(policy, farStart, farStart+hour, now, time.UTC))
+	}
+	{
+		policy := &types.BookingPolicy{MinNotice: 2 * hour}
+		assert.Nil(t, checkBookingPolicy(policy, start, start+hour, now, time.UTC))
+		soon := now.Add(time.Hour).Unix()
+		assert.NotNil(t, checkBookingPolicy(policy, soon, soon+hour, now, time.UTC))
+	}
+	{
+		policy := &types.BookingPolicy{SlotGranularity: hour / 2}
+		assert.Nil(t, checkBookingPolicy(policy, start, start+hour/2, now, time.UTC))
+		assert.NotNil(t, checkBookingPolicy(policy, start+60, start+hour, now, time.UTC))
+		assert.NotNil(t, checkBookingPolicy(policy, start, start+hour-60, now, time.UTC))
+
+		// aligned to wall clock time of the zone
+		loc := time.FixedZone("UTC+0530", 5*60*60+30*60)
+		assert.Nil(t, checkBookingPolicy(&types.BookingPolicy{SlotGranularity: hour}, start+hour/2, start+hour*3/2, now, loc))
+	}
+}
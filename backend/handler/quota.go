@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// startOfWeek returns monday midnight of the week containing t in loc.
+func startOfWeek(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+}
+
+// weeklySeconds splits the ranges at week boundaries of loc and returns the
+// booked seconds keyed by the start timestamp of each week.
+func weeklySeconds(ranges []*types.TimeRange, loc *time.Location) map[int64]int64 {
+	weeks := make(map[int64]int64)
+	for _, tr := range ranges {
+		end := time.Unix(tr.EndTimestamp, 0)
+		for week := startOfWeek(time.Unix(tr.StartTimestamp, 0), loc); week.Before(end); week = week.AddDate(0, 0, 7) {
+			next := week.AddDate(0, 0, 7)
+			start, stop := tr.StartTimestamp, tr.EndTimestamp
+			if week.Unix() > start {
+				start = week.Unix()
+			}
+			if next.Unix() < stop {
+				stop = next.Unix()
+			}
+			weeks[week.Unix()] += stop - start
+		}
+	}
+	return weeks
+}
+
+// checkBookingQuotas checks new schedules of a group, keyed by their room,
+// against every quota of the user which applies to any of the rooms. Each quota
+// is measured against the combined schedules of every room in its scope, so
+// that booking several rooms of a category at once counts them all. The new
+// schedules replace the schedules in stored, which are not counted, when
+// existing ones are moved or the new ones are already stored. Only schedules
+// of a new group count against the number of recurring groups. Bookings of
+// the user are locked until the end of the transaction, so that concurrent
+// requests cannot exceed the quotas together.
+func checkBookingQuotas(tx *sql.Tx, userIdx int64, rangesOfRoom map[int64][]*types.TimeRange, stored []int64, newGroup bool, now time.Time) error {
+	quotas := []*types.BookingQuota{}
+	rangesOfQuota := map[int64][]*types.TimeRange{}
+	for roomId, ranges := range rangesOfRoom {
+		roomQuotas, err := tx.GetBookingQuotasOfRoom(roomId)
+		if err != nil {
+			return err
+		}
+		for _, quota := range roomQuotas {
+			if _, ok := rangesOfQuota[quota.Id]; !ok {
+				quotas = append(quotas, quota)
+			}
+			rangesOfQuota[quota.Id] = append(rangesOfQuota[quota.Id], ranges...)
+		}
+	}
+	if len(quotas) == 0 {
+		return nil
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Id < quotas[j].Id })
+	if err := tx.LockUserBookings(userIdx); err != nil {
+		return err
+	}
+
+	for _, quota := range quotas {
+		ranges := rangesOfQuota[quota.Id]
+		if quota.MaxHoursPerWeek > 0 {
+			weeks := weeklySeconds(ranges, config.Config.DefaultLocation)
+			weekStarts := make([]int64, 0, len(weeks))
+			for week := range weeks {
+				weekStarts = append(weekStarts, week)
+			}
+			sort.Slice(weekStarts, func(i, j int) bool { return weekStarts[i] < weekStarts[j] })
+
+			limit := int64(quota.MaxHoursPerWeek) * 60 * 60
+			for _, week := range weekStarts {
+				nextWeek := time.Unix(week, 0).In(config.Config.DefaultLocation).AddDate(0, 0, 7).Unix()
+				booked, err := tx.GetUserBookedSeconds(userIdx, quota, week, nextWeek, stored)
+				if err != nil {
+					return err
+				}
+				if booked+weeks[week] > limit {
+					return policyViolation("cannot book more than %d hours a week", quota.MaxHoursPerWeek)
+				}
+			}
+		}
+		if quota.MaxFutureBookings > 0 {
+			future := 0
+			for _, tr := range ranges {
+				if tr.EndTimestamp > now.Unix() {
+					future++
+				}
+			}
+			if future > 0 {
+				count, err := tx.CountUserFutureBookings(userIdx, quota, stored)
+				if err != nil {
+					return err
+				}
+				if count+future > quota.MaxFutureBookings {
+					return policyViolation("cannot have more than %d upcoming bookings", quota.MaxFutureBookings)
+				}
+			}
+		}
+		// moving schedules of a group does not add a recurring group
+		if quota.MaxRecurringGroups > 0 && newGroup {
+			starts := map[int64]bool{}
+			for _, tr := range ranges {
+				starts[tr.StartTimestamp] = true
+			}
+			if len(starts) > 1 {
+				count, err := tx.CountUserRecurringGroups(userIdx, quota, stored)
+				if err != nil {
+					return err
+				}
+				if count+1 > quota.MaxRecurringGroups {
+					return policyViolation("cannot have more than %d active recurring bookings", quota.MaxRecurringGroups)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func HandleGetBookingQuotas(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	var resp types.GetBookingQuotasResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if ok, err := canAnywhere(tx, p, capabilityConfigureRooms); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		quotas, err := tx.GetAllBookingQuotas()
+		if err != nil {
+			return err
+		}
+		resp.Quotas = []*types.BookingQuota{}
+		for _, quota := range quotas {
+			if ok, err := canIn(tx, p, capabilityConfigureRooms, quota.RoomId, quota.CategoryId); err != nil {
+				return err
+			} else if ok {
+				resp.Quotas = append(resp.Quotas, quota)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get booking quotas", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleAddBookingQuota(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.AddBookingQuotaReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	quota := &types.BookingQuota{
+		RoomId:             req.RoomId,
+		CategoryId:         req.CategoryId,
+		MaxHoursPerWeek:    req.MaxHoursPerWeek,
+		MaxFutureBookings:  req.MaxFutureBookings,
+		MaxRecurringGroups: req.MaxRecurringGroups,
+	}
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, quota.RoomId, quota.CategoryId); err != nil {
+			return err
+		}
+		return tx.AddBookingQuota(quota)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to add booking quota", err)
+		return
+	}
+
+	if b, err := json.Marshal(quota); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleUpdateBookingQuota(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.UpdateBookingQuotaReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		old, err := tx.GetBookingQuotaById(req.QuotaId)
+		if err != nil {
+			return err
+		}
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, old.RoomId, old.CategoryId); err != nil {
+			return err
+		}
+		quota := &types.BookingQuota{
+			Id:                 req.QuotaId,
+			MaxHoursPerWeek:    req.MaxHoursPerWeek,
+			MaxFutureBookings:  req.MaxFutureBookings,
+			MaxRecurringGroups: req.MaxRecurringGroups,
+		}
+		return tx.UpdateBookingQuota(quota)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to update booking quota", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+func HandleDeleteBookingQuota(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.DeleteBookingQuotaReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := context.Background()
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		quota, err := tx.GetBookingQuotaById(req.QuotaId)
+		if err != nil {
+			return err
+		}
+		if err := checkCapabilityIn(tx, p, capabilityConfigureRooms, quota.RoomId, quota.CategoryId); err != nil {
+			return err
+		}
+		return tx.DeleteBookingQuota(req.QuotaId)
+	})
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to delete booking quota", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeeklySeconds(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Seoul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2022-03-06 is a Sunday
+	sunday := time.Date(2022, 3, 6, 22, 0, 0, 0, loc)
+	monday := time.Date(2022, 3, 7, 0, 0, 0, 0, loc)
+	weeks := weeklySeconds([]*types.TimeRange{
+		{StartTimestamp: sunday.Unix(), EndTimestamp: sunday.Add(4 * time.Hour).Unix()},
+		{StartTimestamp: monday.Add(10 * time.Hour).Unix(), EndTimestamp: monday.Add(11 * time.Hour).Unix()},
+	}, loc)
+	assert.Equal(t, map[int64]int64{
+		monday.AddDate(0, 0, -7).Unix(): 2 * 60 * 60,
+		monday.Unix():                   3 * 60 * 60,
+	}, weeks)
+
+	assert.Equal(t, monday, startOfWeek(monday.Add(3*24*time.Hour), loc))
+	assert.Equal(t, monday.AddDate(0, 0, -7), startOfWeek(sunday, loc))
+}
@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+)
+
+// upper bound of periods walked while expanding a rule, so that rules which
+// never produce an occurrence (e.g. BYMONTHDAY=31;BYDAY=1MO) terminate
+const maxRecurrencePeriods = 10000
+
+var (
+	errTooManyOccurrences = errors.New("too many occurrences")
+	errNoOccurrence       = errors.New("recurrence rule produces no occurrence")
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+type weekdayNum struct {
+	// nth occurrence of the weekday in the month, negative counts from the
+	// end, 0 means every occurrence
+	n   int
+	day time.Weekday
+}
+
+// recurrenceRule is a subset of RFC 5545 RRULE.
+type recurrenceRule struct {
+	freq       string
+	interval   int
+	byDay      []weekdayNum
+	byMonthDay []int
+	count      int
+	until      string
+}
+
+// loadLocation resolves an IANA zone name, falling back to the configured
+// default zone if name is empty.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return config.Config.DefaultLocation, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// parseRecurrenceRule parses a rule which ends, with either COUNT or UNTIL.
+func parseRecurrenceRule(s string) (*recurrenceRule, error) {
+	rule, err := parseOpenRecurrenceRule(s)
+	if err != nil {
+		return nil, err
+	}
+	if rule.count == 0 && rule.until == "" {
+		return nil, errors.New("either COUNT or UNTIL is required")
+	}
+	return rule, nil
+}
+
+// parseOpenRecurrenceRule parses a rule which may repeat forever.
+func parseOpenRecurrenceRule(s string) (*recurrenceRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	rule := &recurrenceRule{interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("malformed rule part %q", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				rule.freq = value
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			rule.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid COUNT %q", value)
+			}
+			rule.count = n
+		case "UNTIL":
+			if _, err := parseUntil(value, time.UTC); err != nil {
+				return nil, err
+			}
+			rule.until = value
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wn, err := parseWeekdayNum(d)
+				if err != nil {
+					return nil, err
+				}
+				rule.byDay = append(rule.byDay, wn)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", d)
+				}
+				rule.byMonthDay = append(rule.byMonthDay, n)
+			}
+		case "WKST":
+			if value != "MO" {
+				return nil, fmt.Errorf("unsupported WKST %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if rule.freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if rule.count > 0 && rule.until != "" {
+		return nil, errors.New("COUNT and UNTIL are mutually exclusive")
+	}
+	if rule.freq == "YEARLY" && (len(rule.byDay) > 0 || len(rule.byMonthDay) > 0) {
+		return nil, errors.New("BYDAY and BYMONTHDAY are not supported with FREQ=YEARLY")
+	}
+	for _, wn := range rule.byDay {
+		if wn.n != 0 && rule.freq != "MONTHLY" {
+			return nil, errors.New("numbered BYDAY is only supported with FREQ=MONTHLY")
+		}
+	}
+	return rule, nil
+}
+
+// ruleUntil returns the rule ending at until instead of its COUNT or UNTIL.
+func ruleUntil(rrule string, until time.Time) string {
+	parts := []string{}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(rrule), "RRULE:"), ";") {
+		key := strings.ToUpper(strings.SplitN(part, "=", 2)[0])
+		if part == "" || key == "COUNT" || key == "UNTIL" {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	parts = append(parts, "UNTIL="+until.UTC().Format("20060102T150405Z"))
+	return strings.Join(parts, ";")
+}
+
+// recurrenceOf returns a rule derived from rrule, and the dates to exclude from
+// it, which yield exactly the given sorted starts from the first of them in
+// loc. An empty rule is returned if the rule cannot yield them, such as when
+// they were moved to weekdays BYDAY does not list.
+func recurrenceOf(rrule string, starts []int64, loc *time.Location) (string, []int64) {
+	if len(starts) == 0 {
+		return "", nil
+	}
+	derived := ruleUntil(rrule, time.Unix(starts[len(starts)-1], 0))
+	rule, err := parseRecurrenceRule(derived)
+	if err != nil {
+		return "", nil
+	}
+	expanded, err := rule.expand(time.Unix(starts[0], 0).In(loc), nil, config.Config.ScheduleRepeatLimit)
+	if err != nil {
+		return "", nil
+	}
+	wanted := make(map[int64]bool, len(starts))
+	for _, start := range starts {
+		wanted[start] = true
+	}
+	exDates := []int64{}
+	for _, t := range expanded {
+		if wanted[t.Unix()] {
+			delete(wanted, t.Unix())
+		} else {
+			exDates = append(exDates, t.Unix())
+		}
+	}
+	if len(wanted) > 0 {
+		return "", nil
+	}
+	return derived, exDates
+}
+
+func parseWeekdayNum(s string) (weekdayNum, error) {
+	if len(s) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	day, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+	}
+	wn := weekdayNum{day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return weekdayNum{}, fmt.Errorf("invalid BYDAY %q", s)
+		}
+		wn.n = n
+	}
+	return wn, nil
+}
+
+// parseUntil accepts the UTC date-time form (20060102T150405Z), the floating
+// date-time form which is read in loc, and the date form which includes the
+// whole day.
+func parseUntil(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", s, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102", s, loc); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid UNTIL %q", s)
+}
+
+// expand returns the start times of every occurrence of the rule beginning at
+// dtstart, skipping the ones listed in exDates. Occurrences keep the wall
+// clock time of dtstart in its location. An error is returned when more than
+// limit occurrences would be produced.
+func (rule *recurrenceRule) expand(dtstart time.Time, exDates []int64, limit int) ([]time.Time, error) {
+	var until time.Time
+	if rule.until != "" {
+		u, err := parseUntil(rule.until, dtstart.Location())
+		if err != nil {
+			return nil, err
+		}
+		until = u
+	}
+	excluded := make(map[int64]bool, len(exDates))
+	for _, ts := range exDates {
+		excluded[ts] = true
+	}
+
+	var (
+		occurrences []time.Time
+		generated   int
+	)
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		candidates := rule.candidates(dtstart, period)
+		for _, t := range candidates {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !until.IsZero() && t.After(until) {
+				return finishExpansion(occurrences)
+			}
+			generated++
+			if !excluded[t.Unix()] {
+				if len(occurrences) >= limit {
+					return nil, errTooManyOccurrences
+				}
+				occurrences = append(occurrences, t)
+			}
+			if rule.count > 0 && generated >= rule.count {
+				return finishExpansion(occurrences)
+			}
+		}
+	}
+	return finishExpansion(occurrences)
+}
+
+// between returns the start times of the occurrences of the rule beginning at
+// dtstart which start within [start, end). Unless the rule has COUNT, periods
+// before start are not walked, so that rules which repeat forever can be
+// expanded near any time.
+func (rule *recurrenceRule) between(dtstart time.Time, start time.Time, end time.Time) ([]time.Time, error) {
+	var until time.Time
+	if rule.until != "" {
+		u, err := parseUntil(rule.until, dtstart.Location())
+		if err != nil {
+			return nil, err
+		}
+		until = u
+	}
+
+	first := 0
+	if rule.count == 0 {
+		// the period before the one containing start may still reach it
+		first = rule.periodsUntil(dtstart, start) - 1
+		if first < 0 {
+			first = 0
+		}
+	}
+	var (
+		occurrences []time.Time
+		generated   int
+	)
+	for period := first; period < first+maxRecurrencePeriods; period++ {
+		for _, t := range rule.candidates(dtstart, period) {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !t.Before(end) || (!until.IsZero() && t.After(until)) {
+				return occurrences, nil
+			}
+			generated++
+			if !t.Before(start) {
+				occurrences = append(occurrences, t)
+			}
+			if rule.count > 0 && generated >= rule.count {
+				return occurrences, nil
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+// periodsUntil returns the number of whole periods of the rule from the one
+// containing dtstart to the one containing t, or 0 if t is before dtstart.
+func (rule *recurrenceRule) periodsUntil(dtstart time.Time, t time.Time) int {
+	if !t.After(dtstart) {
+		return 0
+	}
+	t = t.In(dtstart.Location())
+	y, m, d := dtstart.Date()
+	ty, tm, td := t.Date()
+	days := int(time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Sub(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+
+	var periods int
+	switch rule.freq {
+	case "DAILY":
+		periods = days
+	case "WEEKLY":
+		periods = (days + (int(dtstart.Weekday())+6)%7) / 7
+	case "MONTHLY":
+		periods = (ty-y)*12 + int(tm) - int(m)
+	case "YEARLY":
+		periods = ty - y
+	}
+	return periods / rule.interval
+}
+
+func finishExpansion(occurrences []time.Time) ([]time.Time, error) {
+	if len(occurrences) == 0 {
+		return nil, errNoOccurrence
+	}
+	return occurrences, nil
+}
+
+// candidates returns the sorted occurrence candidates within the given
+// period, counted from the period that contains dtstart.
+func (rule *recurrenceRule) candidates(dtstart time.Time, period int) []time.Time {
+	y, m, d := dtstart.Date()
+	hh, mm, ss := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, 0, loc)
+	}
+	step := period * rule.interval
+
+	var days []time.Time
+	switch rule.freq {
+	case "DAILY":
+		day := at(y, m, d+step)
+		if rule.matchesByDay(day) && rule.matchesByMonthDay(day) {
+			days = append(days, day)
+		}
+	case "WEEKLY":
+		offset := (int(dtstart.Weekday()) + 6) % 7
+		monday := at(y, m, d-offset+7*step)
+		for i := 0; i < 7; i++ {
+			day := at(monday.Year(), monday.Month(), monday.Day()+i)
+			if len(rule.byDay) == 0 {
+				if day.Weekday() != dtstart.Weekday() {
+					continue
+				}
+			} else if !rule.matchesByDay(day) {
+				continue
+			}
+			if rule.matchesByMonthDay(day) {
+				days = append(days, day)
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(y, m+time.Month(step), 1, 0, 0, 0, 0, loc)
+		lastDay := daysIn(first.Year(), first.Month(), loc)
+		for dd := 1; dd <= lastDay; dd++ {
+			day := at(first.Year(), first.Month(), dd)
+			if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+				if dd != d {
+					continue
+				}
+			} else if !rule.matchesByMonthDay(day) || !rule.matchesByDayInMonth(day, lastDay) {
+				continue
+			}
+			days = append(days, day)
+		}
+	case "YEARLY":
+		if d <= daysIn(y+step, m, loc) {
+			days = append(days, at(y+step, m, d))
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+func (rule *recurrenceRule) matchesByDay(t time.Time) bool {
+	if len(rule.byDay) == 0 {
+		return true
+	}
+	for _, wn := range rule.byDay {
+		if wn.day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *recurrenceRule) matchesByDayInMonth(t time.Time, lastDay int) bool {
+	if len(rule.byDay) == 0 {
+		return true
+	}
+	for _, wn := range rule.byDay {
+		if wn.day != t.Weekday() {
+			continue
+		}
+		switch {
+		case wn.n == 0:
+			return true
+		case wn.n > 0 && (t.Day()-1)/7+1 == wn.n:
+			return true
+		case wn.n < 0 && (lastDay-t.Day())/7+1 == -wn.n:
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *recurrenceRule) matchesByMonthDay(t time.Time) bool {
+	if len(rule.byMonthDay) == 0 {
+		return true
+	}
+	lastDay := daysIn(t.Year(), t.Month(), t.Location())
+	for _, n := range rule.byMonthDay {
+		if n == t.Day() || (n < 0 && lastDay+n+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month, loc *time.Location) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+}
+
+// shiftWallClock moves t by the difference between from and to in calendar
+// days and clock time of loc, so that the shifted time keeps its wall clock
+// offset from to across DST transitions.
+func shiftWallClock(t, from, to time.Time, loc *time.Location) time.Time {
+	from, to, t = from.In(loc), to.In(loc), t.In(loc)
+	fy, fm, fd := from.Date()
+	ty, tm, td := to.Date()
+	days := int(time.Date(ty, tm, td, 0, 0, 0, 0, time.UTC).Sub(time.Date(fy, fm, fd, 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	seconds := secondOfDay(to) - secondOfDay(from)
+
+	y, m, d := t.Date()
+	hh, mm, ss := t.Clock()
+	return time.Date(y, m, d+days, hh, mm, ss+seconds, 0, loc)
+}
+
+func secondOfDay(t time.Time) int {
+	hh, mm, ss := t.Clock()
+	return hh*60*60 + mm*60 + ss
+}
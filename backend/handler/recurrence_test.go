@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expandForTest(t *testing.T, rrule string, dtstart time.Time, exDates []int64, limit int) []time.Time {
+	rule, err := parseRecurrenceRule(rrule)
+	require.Nil(t, err)
+	starts, err := rule.expand(dtstart, exDates, limit)
+	require.Nil(t, err)
+	return starts
+}
+
+func TestParseRecurrenceRule(t *testing.T) {
+	for _, rrule := range []string{
+		"",
+		"INTERVAL=2;COUNT=3",
+		"FREQ=HOURLY;COUNT=3",
+		"FREQ=WEEKLY",
+		"FREQ=WEEKLY;COUNT=3;UNTIL=20220101",
+		"FREQ=WEEKLY;COUNT=0",
+		"FREQ=WEEKLY;INTERVAL=-1;COUNT=3",
+		"FREQ=WEEKLY;BYDAY=XX;COUNT=3",
+		"FREQ=WEEKLY;BYDAY=1MO;COUNT=3",
+		"FREQ=MONTHLY;BYMONTHDAY=32;COUNT=3",
+		"FREQ=YEARLY;BYDAY=MO;COUNT=3",
+		"FREQ=WEEKLY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;COUNT",
+	} {
+		_, err := parseRecurrenceRule(rrule)
+		assert.NotNil(t, err, rrule)
+	}
+
+	rule, err := parseRecurrenceRule("RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=1MO,-1FR;UNTIL=20221231T000000Z")
+	require.Nil(t, err)
+	assert.Equal(t, "MONTHLY", rule.freq)
+	assert.Equal(t, 2, rule.interval)
+	assert.Equal(t, []weekdayNum{{n: 1, day: time.Monday}, {n: -1, day: time.Friday}}, rule.byDay)
+}
+
+func TestExpandRecurrenceRule(t *testing.T) {
+	// 2022-03-01 is a Tuesday
+	dtstart := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	{
+		// every other tuesday
+		starts := expandForTest(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;COUNT=3", dtstart, nil, 10)
+		assert.Equal(t, []time.Time{
+			dtstart,
+			dtstart.AddDate(0, 0, 14),
+			dtstart.AddDate(0, 0, 28),
+		}, starts)
+	}
+	{
+		// weekdays until friday of the next week
+		starts := expandForTest(t, "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;UNTIL=20220311", dtstart, nil, 10)
+		require.Len(t, starts, 9)
+		assert.Equal(t, dtstart, starts[0])
+		assert.Equal(t, time.Date(2022, 3, 4, 10, 0, 0, 0, time.UTC), starts[3])
+		assert.Equal(t, time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC), starts[4])
+		assert.Equal(t, time.Date(2022, 3, 11, 10, 0, 0, 0, time.UTC), starts[8])
+	}
+	{
+		// first monday of each month
+		starts := expandForTest(t, "FREQ=MONTHLY;BYDAY=1MO;COUNT=3", dtstart, nil, 10)
+		assert.Equal(t, []time.Time{
+			time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 4, 4, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 5, 2, 10, 0, 0, 0, time.UTC),
+		}, starts)
+	}
+	{
+		// last day of each month
+		starts := expandForTest(t, "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=2", dtstart, nil, 10)
+		assert.Equal(t, []time.Time{
+			time.Date(2022, 3, 31, 10, 0, 0, 0, time.UTC),
+			time.Date(2022, 4, 30, 10, 0, 0, 0, time.UTC),
+		}, starts)
+	}
+	{
+		// excluded dates are counted by COUNT
+		exDates := []int64{dtstart.AddDate(0, 0, 1).Unix()}
+		starts := expandForTest(t, "FREQ=DAILY;COUNT=3", dtstart, exDates, 10)
+		assert.Equal(t, []time.Time{dtstart, dtstart.AddDate(0, 0, 2)}, starts)
+	}
+	{
+		// too many occurrences
+		rule, err := parseRecurrenceRule("FREQ=DAILY;COUNT=11")
+		require.Nil(t, err)
+		_, err = rule.expand(dtstart, nil, 10)
+		assert.Equal(t, errTooManyOccurrences, err)
+	}
+	{
+		// no occurrence
+		rule, err := parseRecurrenceRule("FREQ=MONTHLY;BYMONTHDAY=31;BYDAY=1MO;COUNT=1")
+		require.Nil(t, err)
+		_, err = rule.expand(dtstart, nil, 10)
+		assert.Equal(t, errNoOccurrence, err)
+	}
+	{
+		// rules without COUNT or UNTIL are only expanded between given times
+		_, err := parseRecurrenceRule("FREQ=MONTHLY;INTERVAL=2")
+		assert.NotNil(t, err)
+		rule, err := parseOpenRecurrenceRule("FREQ=MONTHLY;INTERVAL=2")
+		require.Nil(t, err)
+		starts, err := rule.between(dtstart, dtstart.AddDate(10, 1, 0), dtstart.AddDate(10, 5, 0))
+		require.Nil(t, err)
+		assert.Equal(t, []time.Time{dtstart.AddDate(10, 2, 0), dtstart.AddDate(10, 4, 0)}, starts)
+
+		// COUNT is counted from dtstart
+		rule, err = parseOpenRecurrenceRule("FREQ=DAILY;COUNT=5")
+		require.Nil(t, err)
+		starts, err = rule.between(dtstart, dtstart.AddDate(0, 0, 3), dtstart.AddDate(0, 0, 10))
+		require.Nil(t, err)
+		assert.Equal(t, []time.Time{dtstart.AddDate(0, 0, 3), dtstart.AddDate(0, 0, 4)}, starts)
+	}
+}
+
+func TestExpandRecurrenceRuleAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.Nil(t, err)
+	// daylight saving time starts at 2022-03-13 in new york
+	dtstart := time.Date(2022, 3, 7, 10, 0, 0, 0, loc)
+	starts := expandForTest(t, "FREQ=WEEKLY;COUNT=2", dtstart, nil, 10)
+	require.Len(t, starts, 2)
+	assert.Equal(t, 10, starts[1].Hour())
+	assert.Equal(t, int64(7*24*60*60-60*60), starts[1].Unix()-starts[0].Unix())
+
+	// UNTIL without zone is read in the zone of dtstart
+	starts = expandForTest(t, "FREQ=DAILY;UNTIL=20220308T100000", dtstart, nil, 10)
+	assert.Len(t, starts, 2)
+}
+
+func TestShiftWallClock(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.Nil(t, err)
+	from := time.Date(2022, 3, 7, 10, 0, 0, 0, loc)
+	to := time.Date(2022, 3, 8, 11, 30, 0, 0, loc)
+
+	assert.Equal(t, to.Unix(), shiftWallClock(from, from, to, loc).Unix())
+	// following occurrence after the DST transition keeps its wall clock time
+	next := shiftWallClock(from.AddDate(0, 0, 7), from, to, loc)
+	assert.Equal(t, time.Date(2022, 3, 15, 11, 30, 0, 0, loc).Unix(), next.Unix())
+}
+
+func TestRecurrenceOf(t *testing.T) {
+	dtstart := time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC)
+	at := func(days int) int64 {
+		return dtstart.AddDate(0, 0, days).Unix()
+	}
+
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20220306T235959Z",
+		ruleUntil("RRULE:FREQ=WEEKLY;COUNT=10;BYDAY=MO,WE", dtstart.Add(-10*time.Hour-time.Second)))
+
+	rrule, exDates := recurrenceOf("FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", []int64{at(0), at(7), at(9)}, time.UTC)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20220316T100000Z", rrule)
+	assert.Equal(t, []int64{at(2)}, exDates)
+
+	// moved to a day the rule does not yield
+	rrule, exDates = recurrenceOf("FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", []int64{at(1), at(3)}, time.UTC)
+	assert.Equal(t, "", rrule)
+	assert.Nil(t, exDates)
+}
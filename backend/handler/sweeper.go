@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/bacchus-snu/reservation/notify"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+// RunSweeper calls SweepExpiredHolds and ReleaseNoShows every interval until
+// ctx is done.
+func RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := SweepExpiredHolds(); err != nil {
+				logrus.WithError(err).Error("failed to sweep expired holds")
+			}
+			if err := ReleaseNoShows(); err != nil {
+				logrus.WithError(err).Error("failed to release no-shows")
+			}
+		}
+	}
+}
+
+// SweepExpiredHolds releases held groups which were not confirmed in time and
+// offers their slots to waiters. Waitlist entries which have started are
+// expired.
+func SweepExpiredHolds() error {
+	var notifications []*notify.Notification
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.ExpireWaitlistEntries(); err != nil {
+			return err
+		}
+		released, err := tx.ReleaseExpiredHolds()
+		if err != nil {
+			return err
+		}
+		for _, s := range released {
+			ranges := []*types.TimeRange{{StartTimestamp: s.StartTimestamp, EndTimestamp: s.EndTimestamp}}
+			n, err := promoteWaiters(tx, s.RoomId, ranges)
+			if err != nil {
+				return err
+			}
+			notifications = append(notifications, n...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	notify.Send(notifications...)
+	return nil
+}
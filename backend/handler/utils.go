@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/sql"
 	"github.com/bacchus-snu/reservation/types"
 	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/sirupsen/logrus"
@@ -20,6 +21,12 @@ type JWTPayload struct {
 	UserIdx       int    `json:"userIdx"`
 	Username      string `json:"username"`
 	PermissionIdx int    `json:"permission"`
+	// global roles granted by the id service
+	Roles []string `json:"roles,omitempty"`
+
+	// id and scope of the api token the request was made with, 0 for jwts
+	TokenId int64  `json:"-"`
+	Scope   string `json:"-"`
 }
 
 func ParseToken(r *http.Request) (*JWTPayload, bool) {
@@ -32,6 +39,9 @@ func ParseToken(r *http.Request) (*JWTPayload, bool) {
 		return nil, false
 	}
 	tokenStr := h[7:]
+	if strings.HasPrefix(tokenStr, apiTokenPrefix) {
+		return parseAPIToken(tokenStr)
+	}
 
 	token, err := jwt.ParseSigned(tokenStr)
 	if err != nil {
@@ -39,9 +49,21 @@ func ParseToken(r *http.Request) (*JWTPayload, bool) {
 		return nil, false
 	}
 
-	payload := new(JWTPayload)
-	if err := token.Claims(config.Config.JWTPublicKey, payload); err != nil {
-		logrus.WithError(err).Error("failed to verify signature")
+	// tokens without a key id are tried against every key
+	kid := ""
+	if len(token.Headers) > 0 {
+		kid = token.Headers[0].KeyID
+	}
+	var payload *JWTPayload
+	for _, key := range config.Config.JWTKeys.Lookup(kid) {
+		claims := new(JWTPayload)
+		if err := token.Claims(key.Key, claims); err == nil {
+			payload = claims
+			break
+		}
+	}
+	if payload == nil {
+		logrus.WithField("kid", kid).Error("failed to verify signature")
 		return nil, false
 	}
 
@@ -61,10 +83,6 @@ func ParseToken(r *http.Request) (*JWTPayload, bool) {
 	return payload, true
 }
 
-func isAdmin(permissionIdx int) bool {
-	return config.Config.AdminPermissionIdx == permissionIdx
-}
-
 func httpError(w http.ResponseWriter, statusCode int, msg string, errs ...error) {
 	for _, err := range errs {
 		logrus.WithError(err).Error(msg)
@@ -76,3 +94,14 @@ func httpError(w http.ResponseWriter, statusCode int, msg string, errs ...error)
 	b, _ := json.Marshal(errResp)
 	w.Write(b)
 }
+
+func conflictError(w http.ResponseWriter, msg string, err *sql.ConflictError) {
+	logrus.WithError(err).Info(msg)
+	conflictResp := types.ConflictResp{
+		Msg:       msg,
+		Conflicts: err.Schedules,
+	}
+	w.WriteHeader(http.StatusConflict)
+	b, _ := json.Marshal(conflictResp)
+	w.Write(b)
+}
@@ -0,0 +1,460 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/bacchus-snu/reservation/notify"
+	"github.com/bacchus-snu/reservation/sql"
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/sirupsen/logrus"
+)
+
+var errHoldExpired = errors.New("hold has expired")
+
+func formatTimeRange(startTimestamp int64, endTimestamp int64) string {
+	const layout = "2006-01-02 15:04"
+	loc := config.Config.DefaultLocation
+	return fmt.Sprintf("%s - %s", time.Unix(startTimestamp, 0).In(loc).Format(layout), time.Unix(endTimestamp, 0).In(loc).Format(layout))
+}
+
+// promoteWaiters offers the freed time ranges of the room to waiting entries
+// in the order they joined. Entries which still conflict with other schedules
+// keep waiting. The returned notifications are to be sent once the
+// transaction is committed.
+func promoteWaiters(tx *sql.Tx, roomId int64, freed []*types.TimeRange) ([]*notify.Notification, error) {
+	notifications := []*notify.Notification{}
+	for _, tr := range freed {
+		entries, err := tx.GetWaitingEntries(roomId, tr.StartTimestamp, tr.EndTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			n, err := promoteWaiter(tx, entry, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				notifications = append(notifications, n)
+			}
+		}
+	}
+	return notifications, nil
+}
+
+// waiterRoles returns the roles of the user of the entry in its room. Entries
+// are promoted without a token of the user, so only the default role and
+// grants of the user apply.
+func waiterRoles(tx *sql.Tx, entry *types.WaitlistEntry) ([]string, error) {
+	roles, err := tx.GetRolesOfUser(entry.UserIdx, entry.RoomId, -1)
+	if err != nil {
+		return nil, err
+	}
+	if config.Config.DefaultRole != "" {
+		roles = append(roles, config.Config.DefaultRole)
+	}
+	return roles, nil
+}
+
+// checkWaiter checks the entry as a new booking at the time of promotion, as
+// the room or the bookings of the user may have changed since it joined.
+// Policies and quotas are not checked if override is set.
+func checkWaiter(tx *sql.Tx, entry *types.WaitlistEntry, override bool, now time.Time) error {
+	ranges := []*types.TimeRange{{StartTimestamp: entry.StartTimestamp, EndTimestamp: entry.EndTimestamp}}
+	if !override {
+		policy, err := tx.GetEffectiveBookingPolicy(entry.RoomId)
+		if err != nil {
+			return err
+		}
+		if err := checkBookingPolicy(policy, entry.StartTimestamp, entry.EndTimestamp, now, config.Config.DefaultLocation); err != nil {
+			return err
+		}
+	}
+	if err := checkRoomOpen(tx, entry.RoomId, ranges); err != nil {
+		return err
+	}
+	if !override {
+		return checkBookingQuotas(tx, entry.UserIdx, map[int64][]*types.TimeRange{entry.RoomId: ranges}, nil, true, now)
+	}
+	return nil
+}
+
+// expireWaiter expires the entry which can never be booked, and returns the
+// notification telling the user why.
+func expireWaiter(tx *sql.Tx, entry *types.WaitlistEntry, why string) (*notify.Notification, error) {
+	if err := tx.SetWaitlistEntryStatus(entry.Id, types.WaitlistStatusExpired, -1); err != nil {
+		return nil, err
+	}
+	return &notify.Notification{
+		UserIdx: entry.UserIdx,
+		Email:   entry.Email,
+		Subject: "A slot you are waiting for cannot be booked",
+		Message: why + ", and you are removed from its waitlist.",
+	}, nil
+}
+
+// promoteWaiter books the entry, or holds it for confirmation, if its time
+// range is free. It returns nil if the entry has to keep waiting, which it
+// does while booking it would violate policies or quotas as well. Entries
+// asking for more seats than the room has left, or of users who can no
+// longer book the room, are expired.
+func promoteWaiter(tx *sql.Tx, entry *types.WaitlistEntry, now time.Time) (*notify.Notification, error) {
+	room, err := tx.GetRoomById(entry.RoomId)
+	if err != nil {
+		return nil, err
+	}
+	when := formatTimeRange(entry.StartTimestamp, entry.EndTimestamp)
+	if (!room.Shared && entry.Seats != 0) || entry.Seats < 0 || entry.Seats > room.Seats {
+		return expireWaiter(tx, entry, fmt.Sprintf("%s in %s no longer has the seats you are waiting for", when, room.Name))
+	}
+	roles, err := waiterRoles(tx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if !hasCapability(roles, capabilityBook) {
+		return expireWaiter(tx, entry, fmt.Sprintf("You can no longer book %s for %s", room.Name, when))
+	}
+	override := hasCapability(roles, capabilityOverridePolicies)
+	var policyErr *policyViolationError
+	if err := checkWaiter(tx, entry, override, now); errors.As(err, &policyErr) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	g := &types.ScheduleGroup{
+		RoomId:      entry.RoomId,
+		UserIdx:     entry.UserIdx,
+		Reservee:    entry.Reservee,
+		Email:       entry.Email,
+		PhoneNumber: entry.PhoneNumber,
+		Reason:      entry.Reason,
+		Timezone:    config.Config.DefaultLocation.String(),
+	}
+	status := types.WaitlistStatusBooked
+	if entry.Promotion == types.WaitlistPromotionHold {
+		g.Status = types.ScheduleGroupStatusHeld
+		g.HoldExpiresAt = now.Add(config.Config.WaitlistHoldTTL).Unix()
+		status = types.WaitlistStatusOffered
+	} else if room.RequiresApproval && !override {
+		g.Status = types.ScheduleGroupStatusPending
+	}
+	if err := tx.AddScheduleGroup(g); err != nil {
+		return nil, err
+	}
+
+	s := &types.Schedule{
+		RoomId:          entry.RoomId,
+		ScheduleGroupId: g.Id,
+		StartTimestamp:  entry.StartTimestamp,
+		EndTimestamp:    entry.EndTimestamp,
+		Seats:           entry.Seats,
+	}
+	var conflictErr *sql.ConflictError
+	if err := tx.AddSchedule(s); errors.As(err, &conflictErr) {
+		return nil, tx.DeleteScheduleGroup(g.Id)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := tx.SetWaitlistEntryStatus(entry.Id, status, g.Id); err != nil {
+		return nil, err
+	}
+
+	n := &notify.Notification{
+		UserIdx: entry.UserIdx,
+		Email:   entry.Email,
+	}
+	if status == types.WaitlistStatusOffered {
+		n.Subject = "A slot you are waiting for is available"
+		n.Message = fmt.Sprintf("%s in %s is held for you until %s. Confirm it before then to keep it.",
+			when, room.Name, time.Unix(g.HoldExpiresAt, 0).In(config.Config.DefaultLocation).Format("2006-01-02 15:04"))
+	} else {
+		n.Subject = "A slot you are waiting for is booked"
+		n.Message = fmt.Sprintf("%s in %s is booked for you.", when, room.Name)
+	}
+	return n, nil
+}
+
+func HandleJoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.JoinWaitlistReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	if req.StartTimestamp >= req.EndTimestamp {
+		httpError(w, http.StatusBadRequest, "invalid time range")
+		return
+	}
+	now := time.Now()
+	if req.StartTimestamp <= now.Unix() {
+		httpError(w, http.StatusBadRequest, "cannot wait for a slot in the past")
+		return
+	}
+	switch req.Promotion {
+	case "":
+		req.Promotion = types.WaitlistPromotionHold
+	case types.WaitlistPromotionHold, types.WaitlistPromotionBook:
+	default:
+		httpError(w, http.StatusBadRequest, "invalid promotion")
+		return
+	}
+
+	entry := &types.WaitlistEntry{
+		RoomId:         req.RoomId,
+		UserIdx:        int64(p.UserIdx),
+		Reservee:       req.Reservee,
+		Email:          req.Email,
+		PhoneNumber:    req.PhoneNumber,
+		Reason:         req.Reason,
+		StartTimestamp: req.StartTimestamp,
+		EndTimestamp:   req.EndTimestamp,
+		Seats:          req.Seats,
+		Promotion:      req.Promotion,
+	}
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		room, err := tx.GetRoomById(req.RoomId)
+		if err != nil {
+			return err
+		}
+		if (!room.Shared && req.Seats != 0) || req.Seats < 0 || req.Seats > room.Seats {
+			return policyViolation("invalid number of seats")
+		}
+		if ok, err := can(tx, p, capabilityBook, room.Id); err != nil {
+			return err
+		} else if !ok {
+			return errPermissionDenied
+		}
+		override, err := can(tx, p, capabilityOverridePolicies, room.Id)
+		if err != nil {
+			return err
+		}
+		if !override {
+			policy, err := tx.GetEffectiveBookingPolicy(req.RoomId)
+			if err != nil {
+				return err
+			}
+			if err := checkBookingPolicy(policy, req.StartTimestamp, req.EndTimestamp, now, config.Config.DefaultLocation); err != nil {
+				return err
+			}
+		}
+		ranges := []*types.TimeRange{{StartTimestamp: req.StartTimestamp, EndTimestamp: req.EndTimestamp}}
+		if err := checkRoomOpen(tx, req.RoomId, ranges); err != nil {
+			return err
+		}
+		return tx.AddWaitlistEntry(entry)
+	})
+	var policyErr *policyViolationError
+	if errors.Is(err, errPermissionDenied) {
+		httpError(w, http.StatusUnauthorized, "permission denied")
+		return
+	} else if errors.As(err, &policyErr) {
+		httpError(w, http.StatusBadRequest, policyErr.Error())
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to join waitlist", err)
+		return
+	}
+
+	if b, err := json.Marshal(entry); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+func HandleGetMyWaitlistEntries(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+	if !requireCapability(w, p, capabilityView) {
+		return
+	}
+
+	var resp types.GetMyWaitlistEntriesResp
+	ctx := context.Background()
+	err := sql.WithTx(ctx, func(tx *sql.Tx) error {
+		entries, err := tx.GetWaitlistEntriesOfUser(int64(p.UserIdx))
+		if err != nil {
+			return err
+		}
+		resp.Entries = entries
+		return nil
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to get waitlist entries", err)
+		return
+	}
+
+	if b, err := json.Marshal(&resp); err != nil {
+		httpError(w, http.StatusInternalServerError, "failed to marshal response", err)
+		return
+	} else {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			logrus.WithError(err).Error("failed to write success response")
+		}
+	}
+}
+
+// HandleLeaveWaitlist deletes the entry. A slot held for the entry is
+// released and offered to the next waiter.
+func HandleLeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.LeaveWaitlistReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	var notifications []*notify.Notification
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		entry, err := tx.GetWaitlistEntryById(req.EntryId)
+		if err != nil {
+			return err
+		}
+		if !canModifyAsOwner(p, entry.UserIdx) {
+			if ok, err := can(tx, p, capabilityManageBookings, entry.RoomId); err != nil {
+				return err
+			} else if !ok {
+				return errors.New("you are not the owner of waitlist entry")
+			}
+		}
+		if err := tx.DeleteWaitlistEntry(entry.Id); err != nil {
+			return err
+		}
+		if entry.Status != types.WaitlistStatusOffered || entry.ScheduleGroupId < 0 {
+			return nil
+		}
+		g, err := tx.GetScheduleGroupById(entry.ScheduleGroupId)
+		if err != nil {
+			return err
+		}
+		if g.Status != types.ScheduleGroupStatusHeld {
+			return nil
+		}
+		if err := tx.DeleteScheduleGroup(g.Id); err != nil {
+			return err
+		}
+		ranges := []*types.TimeRange{{StartTimestamp: entry.StartTimestamp, EndTimestamp: entry.EndTimestamp}}
+		notifications, err = promoteWaiters(tx, entry.RoomId, ranges)
+		return err
+	})
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to leave waitlist", err)
+		return
+	}
+	notify.Send(notifications...)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
+
+// HandleConfirmWaitlistEntry turns the slot held for the entry into a booking.
+func HandleConfirmWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	var p *JWTPayload
+	p, validToken := ParseToken(r)
+	if !validToken {
+		httpError(w, http.StatusUnauthorized, "failed to verify token")
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to read req body", err)
+		return
+	}
+
+	var req types.ConfirmWaitlistEntryReq
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "failed to deserialize req body", err)
+		return
+	}
+
+	ctx := actorContext(r, p)
+	err = sql.WithTx(ctx, func(tx *sql.Tx) error {
+		entry, err := tx.GetWaitlistEntryById(req.EntryId)
+		if err != nil {
+			return err
+		}
+		if !canModifyAsOwner(p, entry.UserIdx) {
+			return errors.New("you are not the owner of waitlist entry")
+		}
+		if entry.Status != types.WaitlistStatusOffered || entry.ScheduleGroupId < 0 {
+			return errors.New("waitlist entry is not offered a slot")
+		}
+		room, err := tx.GetRoomById(entry.RoomId)
+		if err != nil {
+			return err
+		}
+		override, err := can(tx, p, capabilityOverridePolicies, room.Id)
+		if err != nil {
+			return err
+		}
+		status := types.ScheduleGroupStatusApproved
+		if room.RequiresApproval && !override {
+			status = types.ScheduleGroupStatusPending
+		}
+		if err := tx.ConfirmHeldScheduleGroup(entry.ScheduleGroupId, status); errors.Is(err, sql.ErrNoRowAffected) {
+			return errHoldExpired
+		} else if err != nil {
+			return err
+		}
+		return tx.SetWaitlistEntryStatus(entry.Id, types.WaitlistStatusBooked, entry.ScheduleGroupId)
+	})
+	if errors.Is(err, errHoldExpired) {
+		httpError(w, http.StatusGone, "hold has expired")
+		return
+	} else if err != nil {
+		httpError(w, http.StatusBadRequest, "failed to confirm waitlist entry", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte("ok")); err != nil {
+		logrus.WithError(err).Error("failed to write success response")
+	}
+}
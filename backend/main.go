@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"time"
@@ -26,20 +28,76 @@ func main() {
 	if err := sql.Connect(); err != nil {
 		logrus.WithError(err).Fatal("failed to connect to database")
 	}
+	go config.WatchJWTKeys(context.Background())
 
 	// http handler
 	r := mux.NewRouter()
 	// schedules
 	r.HandleFunc(wrap("/api/schedule/add", handler.HandleAddSchedule)).Methods("POST")
 	r.HandleFunc(wrap("/api/schedule/delete", handler.HandleDeleteSchedule)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/update", handler.HandleUpdateSchedule)).Methods("POST")
 	r.HandleFunc(wrap("/api/schedule/get", handler.HandleGetSchedule)).Methods("GET")
+	r.HandleFunc(wrap("/api/schedule/rooms/get", handler.HandleGetRoomsSchedule)).Methods("GET")
 	r.HandleFunc(wrap("/api/schedule/info/get", handler.HandleGetScheduleInfo)).Methods("GET")
+	r.HandleFunc(wrap("/api/schedule/info/update", handler.HandleUpdateScheduleInfo)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/mine", handler.HandleGetMyScheduleGroups)).Methods("GET")
+	r.HandleFunc(wrap("/api/schedule/hold", handler.HandleHoldSchedule)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/hold/confirm", handler.HandleConfirmHold)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/hold/release", handler.HandleReleaseHold)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/checkin", handler.HandleCheckIn)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/noshows/get", handler.HandleGetNoShows)).Methods("GET")
+	// approval of schedules in restricted rooms
+	r.HandleFunc(wrap("/api/schedule/pending/get", handler.HandleGetPendingScheduleGroups)).Methods("GET")
+	r.HandleFunc(wrap("/api/schedule/approve", handler.HandleApproveScheduleGroup)).Methods("POST")
+	r.HandleFunc(wrap("/api/schedule/reject", handler.HandleRejectScheduleGroup)).Methods("POST")
 	// rooms and categories
 	r.HandleFunc(wrap("/api/rooms/get", handler.HandleGetRoomsAndCategories)).Methods("GET")
+	r.HandleFunc(wrap("/api/rooms/available", handler.HandleGetAvailability)).Methods("GET")
 	r.HandleFunc(wrap("/api/rooms/add", handler.HandleAddRoom)).Methods("POST")
+	r.HandleFunc(wrap("/api/rooms/update", handler.HandleUpdateRoom)).Methods("POST")
 	r.HandleFunc(wrap("/api/rooms/delete", handler.HandleDeleteRoom)).Methods("POST")
+	r.HandleFunc(wrap("/api/rooms/archive", handler.HandleArchiveRoom)).Methods("POST")
+	r.HandleFunc(wrap("/api/rooms/kiosk/issue", handler.HandleIssueKioskToken)).Methods("POST")
 	r.HandleFunc(wrap("/api/categories/add", handler.HandleAddCategory)).Methods("POST")
+	r.HandleFunc(wrap("/api/categories/update", handler.HandleUpdateCategory)).Methods("POST")
 	r.HandleFunc(wrap("/api/categories/delete", handler.HandleDeleteCategory)).Methods("POST")
+	// opening hours and closures
+	r.HandleFunc(wrap("/api/rooms/hours/get", handler.HandleGetOpeningHours)).Methods("GET")
+	r.HandleFunc(wrap("/api/rooms/hours/set", handler.HandleSetOpeningHours)).Methods("POST")
+	r.HandleFunc(wrap("/api/closures/get", handler.HandleGetClosures)).Methods("GET")
+	r.HandleFunc(wrap("/api/closures/add", handler.HandleAddClosure)).Methods("POST")
+	r.HandleFunc(wrap("/api/closures/delete", handler.HandleDeleteClosure)).Methods("POST")
+	// booking policies
+	r.HandleFunc(wrap("/api/policies/get", handler.HandleGetBookingPolicies)).Methods("GET")
+	r.HandleFunc(wrap("/api/policies/add", handler.HandleAddBookingPolicy)).Methods("POST")
+	r.HandleFunc(wrap("/api/policies/update", handler.HandleUpdateBookingPolicy)).Methods("POST")
+	r.HandleFunc(wrap("/api/policies/delete", handler.HandleDeleteBookingPolicy)).Methods("POST")
+	// booking quotas
+	r.HandleFunc(wrap("/api/quotas/get", handler.HandleGetBookingQuotas)).Methods("GET")
+	r.HandleFunc(wrap("/api/quotas/add", handler.HandleAddBookingQuota)).Methods("POST")
+	r.HandleFunc(wrap("/api/quotas/update", handler.HandleUpdateBookingQuota)).Methods("POST")
+	r.HandleFunc(wrap("/api/quotas/delete", handler.HandleDeleteBookingQuota)).Methods("POST")
+	// waitlist
+	r.HandleFunc(wrap("/api/waitlist/join", handler.HandleJoinWaitlist)).Methods("POST")
+	r.HandleFunc(wrap("/api/waitlist/mine", handler.HandleGetMyWaitlistEntries)).Methods("GET")
+	r.HandleFunc(wrap("/api/waitlist/leave", handler.HandleLeaveWaitlist)).Methods("POST")
+	r.HandleFunc(wrap("/api/waitlist/confirm", handler.HandleConfirmWaitlistEntry)).Methods("POST")
+	// roles
+	r.HandleFunc(wrap("/api/grants/get", handler.HandleGetRoleGrants)).Methods("GET")
+	r.HandleFunc(wrap("/api/grants/add", handler.HandleAddRoleGrant)).Methods("POST")
+	r.HandleFunc(wrap("/api/grants/delete", handler.HandleDeleteRoleGrant)).Methods("POST")
+	r.HandleFunc(wrap("/api/managers/get", handler.HandleGetRoomManagers)).Methods("GET")
+	r.HandleFunc(wrap("/api/managers/assign", handler.HandleAssignRoomManager)).Methods("POST")
+	r.HandleFunc(wrap("/api/managers/revoke", handler.HandleRevokeRoomManager)).Methods("POST")
+	// api tokens
+	r.HandleFunc(wrap("/api/tokens/get", handler.HandleGetAPITokens)).Methods("GET")
+	r.HandleFunc(wrap("/api/tokens/issue", handler.HandleIssueAPIToken)).Methods("POST")
+	r.HandleFunc(wrap("/api/tokens/revoke", handler.HandleRevokeAPIToken)).Methods("POST")
+	// audit log
+	r.HandleFunc(wrap("/api/audit/get", handler.HandleGetAuditLog)).Methods("GET")
+
+	// releases holds which were not confirmed in time and no-shows
+	go handler.RunSweeper(context.Background(), config.Config.SweepInterval)
 
 	server := &http.Server{
 		Addr:         config.Config.ListenAddr,
@@ -63,7 +121,18 @@ func wrap(path string, f func(http.ResponseWriter, *http.Request)) (string, func
 				logrus.WithError(panicErr).WithField("path", path).Error("panicked at handler")
 			}
 		}()
-		f(w, r)
+		// the audit log only records ids generated here, and ids given by a
+		// proxy in front are logged next to them so that logs can be matched
+		requestId := fmt.Sprintf("%016x", rand.Uint64())
+		if clientId := r.Header.Get(handler.RequestIdHeader); clientId != "" {
+			logrus.WithFields(logrus.Fields{
+				"path":            path,
+				"requestId":       requestId,
+				"clientRequestId": clientId,
+			}).Info("request id given by client")
+		}
+		w.Header().Set(handler.RequestIdHeader, requestId)
+		f(w, handler.WithRequestId(r, requestId))
 	}
 	return path, wrapped
 }
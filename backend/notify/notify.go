@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bacchus-snu/reservation/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Notification is a message to a user about their reservations.
+type Notification struct {
+	UserIdx int64  `json:"userIdx"`
+	Email   string `json:"email"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Send delivers the notifications in the background. They are posted to the
+// configured webhook, or only logged if none is configured. Failures are
+// logged since the changes notified about are already committed.
+func Send(notifications ...*Notification) {
+	for _, n := range notifications {
+		logrus.WithFields(logrus.Fields{
+			"userIdx": n.UserIdx,
+			"email":   n.Email,
+			"subject": n.Subject,
+		}).Info("notification")
+	}
+	url := config.Config.NotifyWebhookURL
+	if url == "" || len(notifications) == 0 {
+		return
+	}
+	go func() {
+		for _, n := range notifications {
+			if err := post(url, n); err != nil {
+				logrus.WithError(err).WithField("userIdx", n.UserIdx).Error("failed to send notification")
+			}
+		}
+	}()
+}
+
+func post(url string, n *Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context whose transactions record their changes in the
+// audit log as made by the actor.
+func WithActor(ctx context.Context, actor *types.AuditActor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// setActor passes the actor of the context to the audit triggers. Settings are
+// local to the transaction, so that pooled connections do not keep them.
+func (tx *Tx) setActor(ctx context.Context) error {
+	actor, ok := ctx.Value(actorKey{}).(*types.AuditActor)
+	if !ok || actor == nil {
+		return nil
+	}
+	userIdx := ""
+	if actor.UserIdx >= 0 {
+		userIdx = strconv.FormatInt(actor.UserIdx, 10)
+	}
+	query := `
+select set_config('reservation.user_idx', $1, true), set_config('reservation.username', $2, true), set_config('reservation.request_id', $3, true)
+`
+	_, err := tx.tx.Exec(query, userIdx, actor.Username, actor.RequestId)
+	return err
+}
+
+func (tx *Tx) GetAuditLog(req *types.GetAuditLogReq) ([]*types.AuditLogEntry, error) {
+	query := `
+select id, extract(epoch from created_at)::bigint, user_idx, username, action, entity, entity_id, before, after, request_id
+from audit_log
+where ($1::bigint < 0 or user_idx = $1)
+	and ($2 = '' or action = $2)
+	and ($3 = '' or entity = $3)
+	and ($4::bigint < 0 or entity_id = $4)
+	and ($5 = '' or request_id = $5)
+	and ($6::bigint < 0 or created_at >= to_timestamp($6))
+	and ($7::bigint < 0 or created_at < to_timestamp($7))
+order by id desc
+limit $8 offset $9
+`
+	rows, err := tx.tx.Query(query, req.UserIdx, req.Action, req.Entity, req.EntityId, req.RequestId, req.StartTimestamp, req.EndTimestamp, req.PageSize, req.Page*req.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*types.AuditLogEntry{}
+	for rows.Next() {
+		var (
+			entry   types.AuditLogEntry
+			userIdx sql.NullInt64
+			before  []byte
+			after   []byte
+		)
+		if err := rows.Scan(&entry.Id, &entry.Timestamp, &userIdx, &entry.Username, &entry.Action, &entry.Entity, &entry.EntityId, &before, &after, &entry.RequestId); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		entry.UserIdx = -1
+		if userIdx.Valid {
+			entry.UserIdx = userIdx.Int64
+		}
+		entry.Before = before
+		entry.After = after
+		entries = append(entries, &entry)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
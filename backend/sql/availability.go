@@ -0,0 +1,157 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+// GetFreeIntervals returns the rooms matching categoryId (-1 for every
+// category) and minSeats, each with the intervals of at least minDuration
+// seconds within the given time range that no schedule occupies. Shared rooms
+// are free while at least minSeats seats, or a seat if minSeats is 0, are not
+// taken. Rooms without such interval are omitted.
+func (tx *Tx) GetFreeIntervals(startTimestamp int64, endTimestamp int64, minDuration int64, categoryId int64, minSeats int) ([]*types.RoomAvailability, error) {
+	if endTimestamp <= startTimestamp {
+		return nil, errors.New("invalid time range")
+	}
+	query := `
+with params as (
+	select to_timestamp($1) as w_start, to_timestamp($2) as w_end
+),
+candidate_rooms as (
+	select id from rooms where not archived and ($3::bigint < 0 or category_id = $3::bigint) and seats >= $4::integer
+),
+-- seats are counted over the time schedules block, and a booking taking
+-- them keeps its own buffers clear, as checkSeatCapacity checks
+seat_events as (
+	select s.room_id, lower(s.blocked) as e_at, s.seats as delta
+	from schedules s
+	inner join rooms r on (s.room_id = r.id)
+	inner join candidate_rooms cr on (s.room_id = cr.id)
+	cross join params p
+	where s.seats > 0 and s.blocked && tstzrange(p.w_start - make_interval(secs => r.buffer_before), p.w_end + make_interval(secs => r.buffer_after), '[)')
+	union all
+	select s.room_id, upper(s.blocked), -s.seats
+	from schedules s
+	inner join rooms r on (s.room_id = r.id)
+	inner join candidate_rooms cr on (s.room_id = cr.id)
+	cross join params p
+	where s.seats > 0 and s.blocked && tstzrange(p.w_start - make_interval(secs => r.buffer_before), p.w_end + make_interval(secs => r.buffer_after), '[)')
+),
+seat_levels as (
+	select room_id, e_at,
+		sum(sum(delta)) over (partition by room_id order by e_at) as taken,
+		lead(e_at) over (partition by room_id order by e_at) as next_at
+	from seat_events
+	group by room_id, e_at
+),
+busy as (
+	select s.room_id, greatest(lower(s.during), p.w_start) as b_start, least(upper(s.during), p.w_end) as b_end
+	from schedules s
+	inner join candidate_rooms cr on (s.room_id = cr.id)
+	cross join params p
+	where s.seats = 0 and s.during && tstzrange(p.w_start, p.w_end, '[)')
+	union all
+	-- bookings must keep the buffers of the room clear of other bookings
+	select s.room_id,
+		greatest(lower(s.blocked) - make_interval(secs => r.buffer_after), p.w_start),
+		least(upper(s.blocked) + make_interval(secs => r.buffer_before), p.w_end)
+	from schedules s
+	inner join rooms r on (s.room_id = r.id)
+	inner join candidate_rooms cr on (s.room_id = cr.id)
+	cross join params p
+	where s.seats = 0 and (r.buffer_before > 0 or r.buffer_after > 0)
+		and tstzrange(lower(s.blocked) - make_interval(secs => r.buffer_after), upper(s.blocked) + make_interval(secs => r.buffer_before), '[)') && tstzrange(p.w_start, p.w_end, '[)')
+	union all
+	select l.room_id,
+		greatest(l.e_at - make_interval(secs => r.buffer_after), p.w_start),
+		least(l.next_at + make_interval(secs => r.buffer_before), p.w_end)
+	from seat_levels l
+	inner join rooms r on (l.room_id = r.id)
+	cross join params p
+	where l.next_at is not null and r.seats - l.taken < greatest($4::integer, 1)
+		and l.e_at - make_interval(secs => r.buffer_after) < p.w_end and l.next_at + make_interval(secs => r.buffer_before) > p.w_start
+),
+ordered as (
+	select room_id, b_start,
+		max(b_end) over (partition by room_id order by b_start, b_end rows between unbounded preceding and 1 preceding) as prev_end
+	from busy
+),
+gaps as (
+	select o.room_id, coalesce(o.prev_end, p.w_start) as f_start, o.b_start as f_end
+	from ordered o cross join params p
+	union all
+	select cr.id, coalesce((select max(b.b_end) from busy b where b.room_id = cr.id), p.w_start), p.w_end
+	from candidate_rooms cr cross join params p
+)
+select r.id, r.name, r.seats, r.category_id, r.requires_approval, r.shared, r.requires_check_in, r.buffer_before, r.buffer_after, r.archived, extract(epoch from g.f_start)::bigint, extract(epoch from g.f_end)::bigint
+from gaps g
+inner join rooms r on (g.room_id = r.id)
+where g.f_end > g.f_start and g.f_end - g.f_start >= make_interval(secs => $5::double precision)
+order by r.id, g.f_start
+`
+	rows, err := tx.tx.Query(query, startTimestamp, endTimestamp, categoryId, minSeats, minDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	availabilities := []*types.RoomAvailability{}
+	var last *types.RoomAvailability
+	for rows.Next() {
+		var (
+			id             int64
+			name           string
+			seats          int
+			roomCategoryId sql.NullInt64
+			approval       bool
+			shared         bool
+			checkIn        bool
+			bufferBefore   int64
+			bufferAfter    int64
+			archived       bool
+			freeStart      int64
+			freeEnd        int64
+		)
+		if err := rows.Scan(&id, &name, &seats, &roomCategoryId, &approval, &shared, &checkIn, &bufferBefore, &bufferAfter, &archived, &freeStart, &freeEnd); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		if last == nil || last.Room.Id != id {
+			var c int64
+			if roomCategoryId.Valid {
+				c = roomCategoryId.Int64
+			} else {
+				c = -1
+			}
+			last = &types.RoomAvailability{
+				Room: &types.Room{
+					Id:               id,
+					Name:             name,
+					Seats:            seats,
+					CategoryId:       c,
+					RequiresApproval: approval,
+					Shared:           shared,
+					RequiresCheckIn:  checkIn,
+					BufferBefore:     bufferBefore,
+					BufferAfter:      bufferAfter,
+					Archived:         archived,
+				},
+				FreeIntervals: []*types.TimeRange{},
+			}
+			availabilities = append(availabilities, last)
+		}
+		last.FreeIntervals = append(last.FreeIntervals, &types.TimeRange{
+			StartTimestamp: freeStart,
+			EndTimestamp:   freeEnd,
+		})
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return availabilities, nil
+}
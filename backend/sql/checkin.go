@@ -0,0 +1,129 @@
+package sql
+
+import (
+	"github.com/bacchus-snu/reservation/types"
+)
+
+// SetRoomKioskTokenHash replaces the kiosk token of the room.
+func (tx *Tx) SetRoomKioskTokenHash(roomId int64, hash string) error {
+	query := "update rooms set kiosk_token_hash = $2 where id = $1"
+	res, err := tx.tx.Exec(query, roomId, hash)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// GetRoomKioskTokenHash returns the hash of the kiosk token of the room, or
+// an empty string if no token was issued.
+func (tx *Tx) GetRoomKioskTokenHash(roomId int64) (string, error) {
+	var hash string
+	if err := tx.tx.QueryRow("select kiosk_token_hash from rooms where id = $1", roomId).Scan(&hash); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// CheckInSchedule records that the schedule was checked in to. Checking in
+// twice returns ErrNoRowAffected.
+func (tx *Tx) CheckInSchedule(id int64) error {
+	query := "update schedules set checked_in_at = now() where id = $1 and checked_in_at is null"
+	res, err := tx.tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// ReleaseNoShows deletes ongoing approved schedules in rooms requiring
+// check-in which nobody checked in to within gracePeriod seconds after the
+// start, counts them as no-shows of their groups and of their users, and
+// returns them.
+func (tx *Tx) ReleaseNoShows(gracePeriod int64) ([]*types.Schedule, error) {
+	query := `
+delete from schedules s
+using schedule_groups sg, rooms r
+where s.schedule_group_id = sg.id and s.room_id = r.id
+	and r.requires_check_in and sg.status = $2 and s.checked_in_at is null
+	and lower(s.during) + make_interval(secs => $1::double precision) <= now() and upper(s.during) > now()
+returning s.id, s.room_id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint
+`
+	rows, err := tx.tx.Query(query, gracePeriod, types.ScheduleGroupStatusApproved)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	for rows.Next() {
+		var schedule types.Schedule
+		if err := rows.Scan(&schedule.Id, &schedule.RoomId, &schedule.ScheduleGroupId, &schedule.Reservee, &schedule.StartTimestamp, &schedule.EndTimestamp); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedules = append(schedules, &schedule)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	countQuery := "update schedule_groups set no_show_count = no_show_count + 1 where id = $1"
+	recordQuery := `
+insert into no_shows (user_idx, room_id, schedule_group_id, during)
+select user_idx, $2, id, tstzrange(to_timestamp($3), to_timestamp($4), '[)')
+from schedule_groups
+where id = $1
+`
+	for _, schedule := range schedules {
+		if _, err := tx.tx.Exec(countQuery, schedule.ScheduleGroupId); err != nil {
+			return nil, err
+		}
+		if _, err := tx.tx.Exec(recordQuery, schedule.ScheduleGroupId, schedule.RoomId, schedule.StartTimestamp, schedule.EndTimestamp); err != nil {
+			return nil, err
+		}
+	}
+	return schedules, nil
+}
+
+// GetNoShowCounts returns the number of no-shows of each user who has any,
+// most first, including no-shows of deleted schedule groups.
+func (tx *Tx) GetNoShowCounts() ([]*types.UserNoShows, error) {
+	query := `
+select user_idx, count(*)::integer
+from no_shows
+group by user_idx
+order by 2 desc, user_idx
+`
+	rows, err := tx.tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	users := []*types.UserNoShows{}
+	for rows.Next() {
+		var user types.UserNoShows
+		if err := rows.Scan(&user.UserIdx, &user.NoShowCount); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -0,0 +1,224 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/lib/pq"
+)
+
+func (tx *Tx) GetOpeningHours(roomId int64) ([]*types.OpeningHours, error) {
+	query := "select weekday, open_time, close_time from opening_hours where room_id = $1 order by weekday, open_time"
+	rows, err := tx.tx.Query(query, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	hours := []*types.OpeningHours{}
+	for rows.Next() {
+		var (
+			weekday   int
+			openTime  int
+			closeTime int
+		)
+		if err := rows.Scan(&weekday, &openTime, &closeTime); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		hours = append(hours, &types.OpeningHours{
+			Weekday:   weekday,
+			OpenTime:  openTime,
+			CloseTime: closeTime,
+		})
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return hours, nil
+}
+
+// SetOpeningHours replaces every opening hours of the room.
+func (tx *Tx) SetOpeningHours(roomId int64, hours []*types.OpeningHours) error {
+	if _, err := tx.tx.Exec("delete from opening_hours where room_id = $1", roomId); err != nil {
+		return err
+	}
+	query := "insert into opening_hours (room_id, weekday, open_time, close_time) values ($1, $2, $3, $4)"
+	for _, h := range hours {
+		if _, err := tx.tx.Exec(query, roomId, h.Weekday, h.OpenTime, h.CloseTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanClosures(rows *sql.Rows) ([]*types.Closure, error) {
+	closures := []*types.Closure{}
+	for rows.Next() {
+		var (
+			id             int64
+			roomId         sql.NullInt64
+			categoryId     sql.NullInt64
+			startTimestamp int64
+			endTimestamp   int64
+			rrule          string
+			timezone       string
+			reason         string
+		)
+		if err := rows.Scan(&id, &roomId, &categoryId, &startTimestamp, &endTimestamp, &rrule, &timezone, &reason); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		closure := &types.Closure{
+			Id:             id,
+			RoomId:         -1,
+			CategoryId:     -1,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			RRule:          rrule,
+			Timezone:       timezone,
+			Reason:         reason,
+		}
+		if roomId.Valid {
+			closure.RoomId = roomId.Int64
+		}
+		if categoryId.Valid {
+			closure.CategoryId = categoryId.Int64
+		}
+		closures = append(closures, closure)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return closures, nil
+}
+
+func (tx *Tx) GetAllClosures() ([]*types.Closure, error) {
+	query := `
+select id, room_id, category_id, extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint, rrule, timezone, reason
+from closures
+order by id
+`
+	rows, err := tx.tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanClosures(rows)
+}
+
+func (tx *Tx) GetClosureById(closureId int64) (*types.Closure, error) {
+	query := `
+select id, room_id, category_id, extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint, rrule, timezone, reason
+from closures
+where id = $1
+`
+	rows, err := tx.tx.Query(query, closureId)
+	if err != nil {
+		return nil, err
+	}
+	closures, err := scanClosures(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(closures) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return closures[0], nil
+}
+
+// GetClosuresOfRooms returns closures applied to each room, either directly or
+// through its category.
+func (tx *Tx) GetClosuresOfRooms(roomIds []int64) (map[int64][]*types.Closure, error) {
+	query := `
+select r.id, c.id, c.room_id, c.category_id, extract(epoch from lower(c.during))::bigint, extract(epoch from upper(c.during))::bigint, c.rrule, c.timezone, c.reason
+from rooms r
+inner join closures c on (c.room_id = r.id or c.category_id = r.category_id)
+where r.id = any($1)
+order by r.id, c.id
+`
+	rows, err := tx.tx.Query(query, pq.Array(roomIds))
+	if err != nil {
+		return nil, err
+	}
+
+	closures := map[int64][]*types.Closure{}
+	for rows.Next() {
+		var (
+			targetRoomId   int64
+			id             int64
+			roomId         sql.NullInt64
+			categoryId     sql.NullInt64
+			startTimestamp int64
+			endTimestamp   int64
+			rrule          string
+			timezone       string
+			reason         string
+		)
+		if err := rows.Scan(&targetRoomId, &id, &roomId, &categoryId, &startTimestamp, &endTimestamp, &rrule, &timezone, &reason); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		closure := &types.Closure{
+			Id:             id,
+			RoomId:         -1,
+			CategoryId:     -1,
+			StartTimestamp: startTimestamp,
+			EndTimestamp:   endTimestamp,
+			RRule:          rrule,
+			Timezone:       timezone,
+			Reason:         reason,
+		}
+		if roomId.Valid {
+			closure.RoomId = roomId.Int64
+		}
+		if categoryId.Valid {
+			closure.CategoryId = categoryId.Int64
+		}
+		closures[targetRoomId] = append(closures[targetRoomId], closure)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return closures, nil
+}
+
+func (tx *Tx) AddClosure(closure *types.Closure) error {
+	if closure == nil {
+		return errors.New("closure is nil")
+	}
+	query := `
+insert into closures (room_id, category_id, during, rrule, timezone, reason)
+values (nullif($1::bigint, -1), nullif($2::bigint, -1), tstzrange(to_timestamp($3), to_timestamp($4), '[)'), $5, $6, $7)
+returning id
+`
+	row := tx.tx.QueryRow(query, closure.RoomId, closure.CategoryId, closure.StartTimestamp, closure.EndTimestamp, closure.RRule, closure.Timezone, closure.Reason)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return err
+	}
+	closure.Id = id
+	return nil
+}
+
+func (tx *Tx) DeleteClosure(closureId int64) error {
+	query := "delete from closures where id = $1"
+	res, err := tx.tx.Exec(query, closureId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+const roleGrantColumns = "id, user_idx, role, room_id, category_id"
+
+func scanRoleGrant(row rowScanner) (*types.RoleGrant, error) {
+	var (
+		id         int64
+		userIdx    int64
+		role       string
+		roomId     sql.NullInt64
+		categoryId sql.NullInt64
+	)
+	if err := row.Scan(&id, &userIdx, &role, &roomId, &categoryId); err != nil {
+		return nil, err
+	}
+	grant := &types.RoleGrant{
+		Id:         id,
+		UserIdx:    userIdx,
+		Role:       role,
+		RoomId:     -1,
+		CategoryId: -1,
+	}
+	if roomId.Valid {
+		grant.RoomId = roomId.Int64
+	}
+	if categoryId.Valid {
+		grant.CategoryId = categoryId.Int64
+	}
+	return grant, nil
+}
+
+func (tx *Tx) queryRoleGrants(query string, args ...interface{}) ([]*types.RoleGrant, error) {
+	rows, err := tx.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	grants := []*types.RoleGrant{}
+	for rows.Next() {
+		grant, err := scanRoleGrant(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+func (tx *Tx) GetAllRoleGrants() ([]*types.RoleGrant, error) {
+	query := "select " + roleGrantColumns + " from role_grants order by id"
+	return tx.queryRoleGrants(query)
+}
+
+// GetRoleGrantsOfUser returns every grant of the user, wherever it applies.
+func (tx *Tx) GetRoleGrantsOfUser(userIdx int64) ([]*types.RoleGrant, error) {
+	query := "select " + roleGrantColumns + " from role_grants where user_idx = $1 order by id"
+	return tx.queryRoleGrants(query, userIdx)
+}
+
+func (tx *Tx) GetRoleGrantsWithRole(role string) ([]*types.RoleGrant, error) {
+	query := "select " + roleGrantColumns + " from role_grants where role = $1 order by id"
+	return tx.queryRoleGrants(query, role)
+}
+
+// GetRolesOfUser returns roles granted to the user which apply in the room or
+// in the category. Global grants always apply, grants in a category apply to
+// its rooms, and -1 matches no room or category.
+func (tx *Tx) GetRolesOfUser(userIdx int64, roomId int64, categoryId int64) ([]string, error) {
+	query := `
+select distinct g.role
+from role_grants g
+where g.user_idx = $1 and (
+	(g.room_id is null and g.category_id is null)
+	or g.room_id = $2
+	or g.category_id = $3
+	or g.category_id = (select r.category_id from rooms r where r.id = $2)
+)
+`
+	rows, err := tx.tx.Query(query, userIdx, roomId, categoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (tx *Tx) AddRoleGrant(grant *types.RoleGrant) error {
+	if grant == nil {
+		return errors.New("grant is nil")
+	}
+	query := `
+insert into role_grants (user_idx, role, room_id, category_id)
+values ($1, $2, nullif($3::bigint, -1), nullif($4::bigint, -1))
+returning id
+`
+	row := tx.tx.QueryRow(query, grant.UserIdx, grant.Role, grant.RoomId, grant.CategoryId)
+	var id int64
+	if err := row.Scan(&id); isUniqueViolation(err) {
+		return ErrDuplicateGrant
+	} else if err != nil {
+		return err
+	}
+	grant.Id = id
+	return nil
+}
+
+func (tx *Tx) DeleteRoleGrant(id int64) error {
+	query := "delete from role_grants where id = $1"
+	res, err := tx.tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// DeleteRoleGrantOf revokes the role of the user in the room or category,
+// where -1 means the grant is not scoped to any room or category.
+func (tx *Tx) DeleteRoleGrantOf(userIdx int64, role string, roomId int64, categoryId int64) error {
+	query := `
+delete from role_grants
+where user_idx = $1 and role = $2 and coalesce(room_id, -1) = $3 and coalesce(category_id, -1) = $4
+`
+	res, err := tx.tx.Exec(query, userIdx, role, roomId, categoryId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
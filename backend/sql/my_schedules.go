@@ -0,0 +1,143 @@
+package sql
+
+import (
+	"fmt"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/lib/pq"
+)
+
+// GetScheduleGroupsOfUser returns a page of schedule groups owned by the user,
+// newest first. when is one of "all", "upcoming" (some occurrence has not
+// ended yet) and "past". roomId -1 matches every room, otherwise groups with a
+// schedule in the room match.
+func (tx *Tx) GetScheduleGroupsOfUser(userIdx int64, roomId int64, when string, limit int, offset int) ([]*types.MyScheduleGroup, error) {
+	switch when {
+	case "all", "upcoming", "past":
+	default:
+		return nil, fmt.Errorf("invalid when %q", when)
+	}
+	query := `
+select sg.id, sg.room_id, sg.reservee, sg.email, sg.phone_number, sg.reason, sg.rrule, sg.exdates, sg.timezone, sg.status, sg.status_reason, coalesce(extract(epoch from sg.hold_expires_at)::bigint, 0), sg.no_show_count, count(distinct lower(s.during))
+from schedule_groups sg
+left join schedules s on (s.schedule_group_id = sg.id)
+where sg.user_idx = $1
+group by sg.id
+having ($2::bigint < 0 or sg.room_id = $2::bigint or coalesce(bool_or(s.room_id = $2::bigint), false)) and ($3 = 'all' or ($3 = 'upcoming') = coalesce(max(upper(s.during)) > now(), false))
+order by sg.id desc
+limit $4 offset $5
+`
+	rows, err := tx.tx.Query(query, userIdx, roomId, when, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*types.MyScheduleGroup{}
+	for rows.Next() {
+		var (
+			id              int64
+			roomId          int64
+			reservee        string
+			email           string
+			phoneNumber     string
+			reason          string
+			rrule           string
+			exDates         pq.Int64Array
+			timezone        string
+			status          string
+			statusReason    string
+			holdExpires     int64
+			noShowCount     int
+			occurrenceCount int
+		)
+		if err := rows.Scan(&id, &roomId, &reservee, &email, &phoneNumber, &reason, &rrule, &exDates, &timezone, &status, &statusReason, &holdExpires, &noShowCount, &occurrenceCount); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		if exDates == nil {
+			exDates = pq.Int64Array{}
+		}
+		group := &types.MyScheduleGroup{
+			ScheduleGroup: types.ScheduleGroup{
+				Id:            id,
+				RoomId:        roomId,
+				UserIdx:       userIdx,
+				Reservee:      reservee,
+				Email:         email,
+				PhoneNumber:   phoneNumber,
+				Reason:        reason,
+				RRule:         rrule,
+				ExDates:       []int64(exDates),
+				Timezone:      timezone,
+				Status:        status,
+				StatusReason:  statusReason,
+				HoldExpiresAt: holdExpires,
+				NoShowCount:   noShowCount,
+			},
+			OccurrenceCount:   occurrenceCount,
+			UpcomingSchedules: []*types.Schedule{},
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// GetUpcomingSchedulesInGroups returns schedules of the groups which have not
+// ended yet, ordered by start time.
+func (tx *Tx) GetUpcomingSchedulesInGroups(groupIds []int64) ([]*types.Schedule, error) {
+	query := `
+select s.id, s.room_id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint, sg.status, s.seats, coalesce(s.seat, 0)
+from schedules s
+inner join schedule_groups sg on (s.schedule_group_id = sg.id)
+where s.schedule_group_id = any($1) and upper(s.during) > now()
+order by lower(s.during)
+`
+	rows, err := tx.tx.Query(query, pq.Array(groupIds))
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	for rows.Next() {
+		var (
+			id              int64
+			roomId          int64
+			scheduleGroupId int64
+			reservee        string
+			startTimestamp  int64
+			endTimestamp    int64
+			status          string
+			seats           int
+			seat            int
+		)
+		if err := rows.Scan(&id, &roomId, &scheduleGroupId, &reservee, &startTimestamp, &endTimestamp, &status, &seats, &seat); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedule := &types.Schedule{
+			Id:              id,
+			RoomId:          roomId,
+			ScheduleGroupId: scheduleGroupId,
+			Reservee:        reservee,
+			StartTimestamp:  startTimestamp,
+			EndTimestamp:    endTimestamp,
+			Status:          status,
+			Seats:           seats,
+			Seat:            seat,
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
@@ -0,0 +1,143 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+const bookingPolicyColumns = "id, room_id, category_id, max_duration, max_days_in_advance, min_notice, slot_granularity"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanBookingPolicy(row rowScanner) (*types.BookingPolicy, error) {
+	var (
+		id               int64
+		roomId           sql.NullInt64
+		categoryId       sql.NullInt64
+		maxDuration      int64
+		maxDaysInAdvance int
+		minNotice        int64
+		slotGranularity  int64
+	)
+	if err := row.Scan(&id, &roomId, &categoryId, &maxDuration, &maxDaysInAdvance, &minNotice, &slotGranularity); err != nil {
+		return nil, err
+	}
+	policy := &types.BookingPolicy{
+		Id:               id,
+		RoomId:           -1,
+		CategoryId:       -1,
+		MaxDuration:      maxDuration,
+		MaxDaysInAdvance: maxDaysInAdvance,
+		MinNotice:        minNotice,
+		SlotGranularity:  slotGranularity,
+	}
+	if roomId.Valid {
+		policy.RoomId = roomId.Int64
+	}
+	if categoryId.Valid {
+		policy.CategoryId = categoryId.Int64
+	}
+	return policy, nil
+}
+
+func (tx *Tx) GetAllBookingPolicies() ([]*types.BookingPolicy, error) {
+	query := "select " + bookingPolicyColumns + " from booking_policies order by id"
+	rows, err := tx.tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []*types.BookingPolicy{}
+	for rows.Next() {
+		policy, err := scanBookingPolicy(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (tx *Tx) GetBookingPolicyById(policyId int64) (*types.BookingPolicy, error) {
+	query := "select " + bookingPolicyColumns + " from booking_policies where id = $1"
+	return scanBookingPolicy(tx.tx.QueryRow(query, policyId))
+}
+
+// GetEffectiveBookingPolicy returns the policy of the room, or the policy of
+// its category if the room has none. nil is returned if neither exists.
+func (tx *Tx) GetEffectiveBookingPolicy(roomId int64) (*types.BookingPolicy, error) {
+	query := `
+select bp.id, bp.room_id, bp.category_id, bp.max_duration, bp.max_days_in_advance, bp.min_notice, bp.slot_granularity
+from booking_policies bp, rooms r
+where r.id = $1 and (bp.room_id = r.id or bp.category_id = r.category_id)
+order by bp.room_id is null
+limit 1
+`
+	policy, err := scanBookingPolicy(tx.tx.QueryRow(query, roomId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return policy, err
+}
+
+func (tx *Tx) AddBookingPolicy(policy *types.BookingPolicy) error {
+	if policy == nil {
+		return errors.New("policy is nil")
+	}
+	query := `
+insert into booking_policies (room_id, category_id, max_duration, max_days_in_advance, min_notice, slot_granularity)
+values (nullif($1::bigint, -1), nullif($2::bigint, -1), $3, $4, $5, $6)
+returning id
+`
+	row := tx.tx.QueryRow(query, policy.RoomId, policy.CategoryId, policy.MaxDuration, policy.MaxDaysInAdvance, policy.MinNotice, policy.SlotGranularity)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return err
+	}
+	policy.Id = id
+	return nil
+}
+
+// UpdateBookingPolicy updates the limits of the policy. The room or category
+// which the policy belongs to is not changed.
+func (tx *Tx) UpdateBookingPolicy(policy *types.BookingPolicy) error {
+	if policy == nil {
+		return errors.New("policy is nil")
+	}
+	query := "update booking_policies set max_duration = $2, max_days_in_advance = $3, min_notice = $4, slot_granularity = $5 where id = $1"
+	res, err := tx.tx.Exec(query, policy.Id, policy.MaxDuration, policy.MaxDaysInAdvance, policy.MinNotice, policy.SlotGranularity)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteBookingPolicy(policyId int64) error {
+	query := "delete from booking_policies where id = $1"
+	res, err := tx.tx.Exec(query, policyId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
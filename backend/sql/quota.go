@@ -0,0 +1,232 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/lib/pq"
+)
+
+// class of advisory locks serializing bookings of a user
+const userBookingLockClass = 1
+
+func scanBookingQuota(row rowScanner) (*types.BookingQuota, error) {
+	var (
+		id                 int64
+		roomId             sql.NullInt64
+		categoryId         sql.NullInt64
+		maxHoursPerWeek    int
+		maxFutureBookings  int
+		maxRecurringGroups int
+	)
+	if err := row.Scan(&id, &roomId, &categoryId, &maxHoursPerWeek, &maxFutureBookings, &maxRecurringGroups); err != nil {
+		return nil, err
+	}
+	quota := &types.BookingQuota{
+		Id:                 id,
+		RoomId:             -1,
+		CategoryId:         -1,
+		MaxHoursPerWeek:    maxHoursPerWeek,
+		MaxFutureBookings:  maxFutureBookings,
+		MaxRecurringGroups: maxRecurringGroups,
+	}
+	if roomId.Valid {
+		quota.RoomId = roomId.Int64
+	}
+	if categoryId.Valid {
+		quota.CategoryId = categoryId.Int64
+	}
+	return quota, nil
+}
+
+func (tx *Tx) GetAllBookingQuotas() ([]*types.BookingQuota, error) {
+	query := "select id, room_id, category_id, max_hours_per_week, max_future_bookings, max_recurring_groups from booking_quotas order by id"
+	rows, err := tx.tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := []*types.BookingQuota{}
+	for rows.Next() {
+		quota, err := scanBookingQuota(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+func (tx *Tx) GetBookingQuotaById(quotaId int64) (*types.BookingQuota, error) {
+	query := "select id, room_id, category_id, max_hours_per_week, max_future_bookings, max_recurring_groups from booking_quotas where id = $1"
+	return scanBookingQuota(tx.tx.QueryRow(query, quotaId))
+}
+
+// GetBookingQuotasOfRoom returns the quota of the room and the quota of its
+// category, whichever exists.
+func (tx *Tx) GetBookingQuotasOfRoom(roomId int64) ([]*types.BookingQuota, error) {
+	query := `
+select bq.id, bq.room_id, bq.category_id, bq.max_hours_per_week, bq.max_future_bookings, bq.max_recurring_groups
+from booking_quotas bq, rooms r
+where r.id = $1 and (bq.room_id = r.id or bq.category_id = r.category_id)
+order by bq.id
+`
+	rows, err := tx.tx.Query(query, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	quotas := []*types.BookingQuota{}
+	for rows.Next() {
+		quota, err := scanBookingQuota(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return quotas, nil
+}
+
+// LockUserBookings blocks until other transactions holding the lock of the
+// user finish, so that quota checks of concurrent bookings do not race. The
+// lock is released at the end of the transaction.
+func (tx *Tx) LockUserBookings(userIdx int64) error {
+	_, err := tx.tx.Exec("select pg_advisory_xact_lock($1, $2::integer)", userBookingLockClass, userIdx)
+	return err
+}
+
+// GetUserBookedSeconds returns the total length of schedules of the user in
+// the scope of the quota, clipped to the given time range. Schedules in
+// excluded are not counted.
+func (tx *Tx) GetUserBookedSeconds(userIdx int64, quota *types.BookingQuota, startTimestamp int64, endTimestamp int64, excluded []int64) (int64, error) {
+	if excluded == nil {
+		excluded = []int64{}
+	}
+	query := `
+select coalesce(sum(extract(epoch from upper(s.during * w.range) - lower(s.during * w.range))), 0)::bigint
+from schedules s
+inner join schedule_groups sg on (s.schedule_group_id = sg.id)
+inner join rooms r on (s.room_id = r.id)
+cross join (select tstzrange(to_timestamp($4), to_timestamp($5), '[)') as range) w
+where sg.user_idx = $1 and (s.room_id = $2 or r.category_id = $3) and s.during && w.range and s.id <> all($6)
+`
+	var seconds int64
+	row := tx.tx.QueryRow(query, userIdx, quota.RoomId, quota.CategoryId, startTimestamp, endTimestamp, pq.Array(excluded))
+	if err := row.Scan(&seconds); err != nil {
+		return 0, err
+	}
+	return seconds, nil
+}
+
+// CountUserFutureBookings returns the number of schedules of the user in the
+// scope of the quota which have not ended yet. Schedules in excluded are not
+// counted.
+func (tx *Tx) CountUserFutureBookings(userIdx int64, quota *types.BookingQuota, excluded []int64) (int, error) {
+	if excluded == nil {
+		excluded = []int64{}
+	}
+	query := `
+select count(*)
+from schedules s
+inner join schedule_groups sg on (s.schedule_group_id = sg.id)
+inner join rooms r on (s.room_id = r.id)
+where sg.user_idx = $1 and (s.room_id = $2 or r.category_id = $3) and upper(s.during) > now() and s.id <> all($4)
+`
+	var count int
+	if err := tx.tx.QueryRow(query, userIdx, quota.RoomId, quota.CategoryId, pq.Array(excluded)).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountUserRecurringGroups returns the number of schedule groups of the user in
+// the scope of the quota which have several occurrences and have not ended yet.
+// Schedules of a group booking several rooms at once count as one occurrence.
+// Schedules in excluded are not counted.
+func (tx *Tx) CountUserRecurringGroups(userIdx int64, quota *types.BookingQuota, excluded []int64) (int, error) {
+	if excluded == nil {
+		excluded = []int64{}
+	}
+	query := `
+select count(*) from (
+	select sg.id
+	from schedule_groups sg
+	inner join schedules s on (s.schedule_group_id = sg.id)
+	inner join rooms r on (s.room_id = r.id)
+	where sg.user_idx = $1 and (s.room_id = $2 or r.category_id = $3) and s.id <> all($4)
+	group by sg.id
+	having count(distinct lower(s.during)) > 1 and max(upper(s.during)) > now()
+) g
+`
+	var count int
+	if err := tx.tx.QueryRow(query, userIdx, quota.RoomId, quota.CategoryId, pq.Array(excluded)).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (tx *Tx) AddBookingQuota(quota *types.BookingQuota) error {
+	if quota == nil {
+		return errors.New("quota is nil")
+	}
+	query := `
+insert into booking_quotas (room_id, category_id, max_hours_per_week, max_future_bookings, max_recurring_groups)
+values (nullif($1::bigint, -1), nullif($2::bigint, -1), $3, $4, $5)
+returning id
+`
+	row := tx.tx.QueryRow(query, quota.RoomId, quota.CategoryId, quota.MaxHoursPerWeek, quota.MaxFutureBookings, quota.MaxRecurringGroups)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return err
+	}
+	quota.Id = id
+	return nil
+}
+
+// UpdateBookingQuota updates the limits of the quota. The room or category
+// which the quota belongs to is not changed.
+func (tx *Tx) UpdateBookingQuota(quota *types.BookingQuota) error {
+	if quota == nil {
+		return errors.New("quota is nil")
+	}
+	query := "update booking_quotas set max_hours_per_week = $2, max_future_bookings = $3, max_recurring_groups = $4 where id = $1"
+	res, err := tx.tx.Exec(query, quota.Id, quota.MaxHoursPerWeek, quota.MaxFutureBookings, quota.MaxRecurringGroups)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteBookingQuota(quotaId int64) error {
+	query := "delete from booking_quotas where id = $1"
+	res, err := tx.tx.Exec(query, quotaId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
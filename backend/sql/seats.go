@@ -0,0 +1,180 @@
+package sql
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+// lockSharedRoom returns whether the room is shared and its number of seats.
+// The room is locked until the end of the transaction, so that concurrent
+// bookings of seats in the room are checked one by one.
+func (tx *Tx) lockSharedRoom(roomId int64) (bool, int, error) {
+	var (
+		shared bool
+		seats  int
+	)
+	query := "select shared, seats from rooms where id = $1 for no key update"
+	if err := tx.tx.QueryRow(query, roomId).Scan(&shared, &seats); err != nil {
+		return false, 0, err
+	}
+	return shared, seats, nil
+}
+
+// checkSeatRequest validates the seats requested by the schedule, defaulting
+// to a single seat in shared rooms.
+func checkSeatRequest(schedule *types.Schedule, shared bool, capacity int) error {
+	if !shared {
+		if schedule.Seats != 0 || schedule.Seat != 0 {
+			return errors.New("seats cannot be booked in an exclusive room")
+		}
+		return nil
+	}
+	if schedule.Seat != 0 {
+		if schedule.Seat < 0 || schedule.Seat > capacity {
+			return errors.New("invalid seat")
+		}
+		if schedule.Seats > 1 {
+			return errors.New("a specific seat is booked alone")
+		}
+	}
+	if schedule.Seats == 0 {
+		schedule.Seats = 1
+	}
+	if schedule.Seats < 0 || schedule.Seats > capacity {
+		return errors.New("invalid number of seats")
+	}
+	return nil
+}
+
+// roomBuffers returns the buffers of the room before and after each schedule.
+func (tx *Tx) roomBuffers(roomId int64) (int64, int64, error) {
+	var before, after int64
+	query := "select buffer_before, buffer_after from rooms where id = $1"
+	if err := tx.tx.QueryRow(query, roomId).Scan(&before, &after); err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// blockedSchedules returns copies of the schedules extended by the buffers, so
+// that seats are counted over the time the schedules block like the exclusion
+// constraints of exclusive rooms do.
+func blockedSchedules(schedules []*types.Schedule, before int64, after int64) []*types.Schedule {
+	blocked := make([]*types.Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		b := *s
+		b.StartTimestamp -= before
+		b.EndTimestamp += after
+		blocked = append(blocked, &b)
+	}
+	return blocked
+}
+
+// endOfTime is a timestamp later than any booking.
+const endOfTime = 1 << 40
+
+// CheckRoomSeats returns ConflictError with the upcoming schedules of the room
+// which would not fit if the room had the given number of seats: schedules of
+// a seat beyond them, and schedules overlapping a moment when more seats would
+// be taken. The room is locked until the end of the transaction.
+func (tx *Tx) CheckRoomSeats(roomId int64, seats int, now int64) error {
+	shared, capacity, err := tx.lockSharedRoom(roomId)
+	if err != nil {
+		return err
+	}
+	if !shared || seats >= capacity {
+		return nil
+	}
+	before, after, err := tx.roomBuffers(roomId)
+	if err != nil {
+		return err
+	}
+	upcoming, err := tx.GetOverlappingSchedules(roomId, now, endOfTime)
+	if err != nil {
+		return err
+	}
+	blocked := blockedSchedules(upcoming, before, after)
+	conflicts := []*types.Schedule{}
+	for i, s := range upcoming {
+		if s.EndTimestamp <= now {
+			continue
+		}
+		if s.Seat > seats || peakSeats(blocked, blocked[i].StartTimestamp, blocked[i].EndTimestamp, seats) > seats {
+			conflicts = append(conflicts, s)
+		}
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Schedules: conflicts}
+	}
+	return nil
+}
+
+// checkSeatCapacity returns ConflictError if the seats of the schedule and of
+// the other schedules overlapping it exceed capacity at any moment, including
+// the buffers of the room around each schedule.
+func (tx *Tx) checkSeatCapacity(schedule *types.Schedule, capacity int) error {
+	before, after, err := tx.roomBuffers(schedule.RoomId)
+	if err != nil {
+		return err
+	}
+	overlapping, err := tx.GetOverlappingSchedules(schedule.RoomId, schedule.StartTimestamp, schedule.EndTimestamp)
+	if err != nil {
+		return err
+	}
+	others := []*types.Schedule{}
+	for _, o := range overlapping {
+		if o.Id != schedule.Id {
+			others = append(others, o)
+		}
+	}
+	start, end := schedule.StartTimestamp-before, schedule.EndTimestamp+after
+	if schedule.Seats+peakSeats(blockedSchedules(others, before, after), start, end, capacity) > capacity {
+		return &ConflictError{Schedules: others}
+	}
+	return nil
+}
+
+// peakSeats returns the largest number of seats taken at the same time by the
+// schedules within the given time range. Schedules booking the whole room take
+// every seat.
+func peakSeats(schedules []*types.Schedule, startTimestamp int64, endTimestamp int64, capacity int) int {
+	type event struct {
+		at    int64
+		delta int
+	}
+	events := make([]event, 0, 2*len(schedules))
+	for _, s := range schedules {
+		seats := s.Seats
+		if seats == 0 {
+			seats = capacity
+		}
+		start, end := s.StartTimestamp, s.EndTimestamp
+		if start < startTimestamp {
+			start = startTimestamp
+		}
+		if end > endTimestamp {
+			end = endTimestamp
+		}
+		if start >= end {
+			continue
+		}
+		events = append(events, event{start, seats}, event{end, -seats})
+	}
+	// schedules ending at a moment free their seats before others start
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		return events[i].delta < events[j].delta
+	})
+	peak, current := 0, 0
+	for _, e := range events {
+		current += e.delta
+		if current > peak {
+			peak = current
+		}
+	}
+	return peak
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/bacchus-snu/reservation/config"
 	"github.com/bacchus-snu/reservation/types"
 	goerrors "github.com/go-errors/errors"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,8 +23,24 @@ const (
 
 var (
 	ErrNoRowAffected = errors.New("no rows affected")
+	// ErrDuplicateName is returned when a room or category is given a name
+	// already taken by another one.
+	ErrDuplicateName = errors.New("name already exists")
+	// ErrDuplicateGrant is returned when a role is granted to a user in a
+	// room or category where the user already has it.
+	ErrDuplicateGrant = errors.New("role already granted")
 )
 
+// ConflictError is returned when schedules overlap other schedules of the
+// same room.
+type ConflictError struct {
+	Schedules []*types.Schedule
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflicts with %d schedule(s)", len(e.Schedules))
+}
+
 var db *sql.DB
 
 func Connect() error {
@@ -53,7 +69,7 @@ func TruncateForTest(tableName ...string) error {
 	if !config.Config.IsTest {
 		panic("this function should be called only in test")
 	}
-	_, err := db.Exec(fmt.Sprintf("truncate %s", strings.Join(tableName, ",")))
+	_, err := db.Exec(fmt.Sprintf("truncate %s cascade", strings.Join(tableName, ",")))
 	if err != nil {
 		return err
 	}
@@ -92,6 +108,10 @@ func WithTx(ctx context.Context, f func(*Tx) error) (retErr error) {
 		}
 	}()
 	txWrap := &Tx{tx: tx}
+	if err := txWrap.setActor(ctx); err != nil {
+		shouldRollback = true
+		return err
+	}
 	if err := f(txWrap); err != nil {
 		shouldRollback = true
 		return err
@@ -141,13 +161,52 @@ func (tx *Tx) AddCategory(category *types.Category) error {
 	query := "insert into categories (name, description) values ($1, $2) returning id"
 	row := tx.tx.QueryRow(query, category.Name, category.Description)
 	var id int64
-	if err := row.Scan(&id); err != nil {
+	if err := row.Scan(&id); isUniqueViolation(err) {
+		return ErrDuplicateName
+	} else if err != nil {
 		return err
 	}
 	category.Id = id
 	return nil
 }
 
+func (tx *Tx) GetCategoryById(categoryId int64) (*types.Category, error) {
+	query := "select name, description from categories where id = $1"
+	row := tx.tx.QueryRow(query, categoryId)
+	var (
+		name        string
+		description string
+	)
+	if err := row.Scan(&name, &description); err != nil {
+		return nil, err
+	}
+	category := &types.Category{
+		Id:          categoryId,
+		Name:        name,
+		Description: description,
+	}
+	return category, nil
+}
+
+func (tx *Tx) UpdateCategory(category *types.Category) error {
+	if category == nil {
+		return errors.New("category is nil")
+	}
+	query := "update categories set name = $2, description = $3 where id = $1"
+	res, err := tx.tx.Exec(query, category.Id, category.Name, category.Description)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	} else if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
 func (tx *Tx) DeleteCategory(categoryId int64) error {
 	query := "delete from categories where id = $1"
 	res, err := tx.tx.Exec(query, categoryId)
@@ -163,7 +222,7 @@ func (tx *Tx) DeleteCategory(categoryId int64) error {
 }
 
 func (tx *Tx) GetAllRooms() ([]*types.Room, error) {
-	query := "select id, name, seats, category_id from rooms"
+	query := "select id, name, seats, category_id, requires_approval, shared, requires_check_in, buffer_before, buffer_after, archived from rooms"
 	rows, err := tx.tx.Query(query)
 	if err != nil {
 		return nil, err
@@ -172,12 +231,18 @@ func (tx *Tx) GetAllRooms() ([]*types.Room, error) {
 	rooms := []*types.Room{}
 	for rows.Next() {
 		var (
-			id         int64
-			name       string
-			seats      int
-			categoryId sql.NullInt64
+			id               int64
+			name             string
+			seats            int
+			categoryId       sql.NullInt64
+			requiresApproval bool
+			shared           bool
+			requiresCheckIn  bool
+			bufferBefore     int64
+			bufferAfter      int64
+			archived         bool
 		)
-		if err := rows.Scan(&id, &name, &seats, &categoryId); err != nil {
+		if err := rows.Scan(&id, &name, &seats, &categoryId, &requiresApproval, &shared, &requiresCheckIn, &bufferBefore, &bufferAfter, &archived); err != nil {
 			if err := rows.Close(); err != nil {
 				return nil, err
 			}
@@ -190,10 +255,16 @@ func (tx *Tx) GetAllRooms() ([]*types.Room, error) {
 			c = -1
 		}
 		room := &types.Room{
-			Id:         id,
-			Name:       name,
-			Seats:      seats,
-			CategoryId: c,
+			Id:               id,
+			Name:             name,
+			Seats:            seats,
+			CategoryId:       c,
+			RequiresApproval: requiresApproval,
+			Shared:           shared,
+			RequiresCheckIn:  requiresCheckIn,
+			BufferBefore:     bufferBefore,
+			BufferAfter:      bufferAfter,
+			Archived:         archived,
 		}
 		rooms = append(rooms, room)
 	}
@@ -204,22 +275,213 @@ func (tx *Tx) GetAllRooms() ([]*types.Room, error) {
 	return rooms, nil
 }
 
+func (tx *Tx) GetRoomById(id int64) (*types.Room, error) {
+	query := "select name, seats, category_id, requires_approval, shared, requires_check_in, buffer_before, buffer_after, archived from rooms where id = $1"
+	row := tx.tx.QueryRow(query, id)
+
+	var (
+		name             string
+		seats            int
+		categoryId       sql.NullInt64
+		requiresApproval bool
+		shared           bool
+		requiresCheckIn  bool
+		bufferBefore     int64
+		bufferAfter      int64
+		archived         bool
+	)
+	if err := row.Scan(&name, &seats, &categoryId, &requiresApproval, &shared, &requiresCheckIn, &bufferBefore, &bufferAfter, &archived); err != nil {
+		return nil, err
+	}
+	var c int64
+	if categoryId.Valid {
+		c = categoryId.Int64
+	} else {
+		c = -1
+	}
+	room := &types.Room{
+		Id:               id,
+		Name:             name,
+		Seats:            seats,
+		CategoryId:       c,
+		RequiresApproval: requiresApproval,
+		Shared:           shared,
+		RequiresCheckIn:  requiresCheckIn,
+		BufferBefore:     bufferBefore,
+		BufferAfter:      bufferAfter,
+		Archived:         archived,
+	}
+	return room, nil
+}
+
+func (tx *Tx) GetRoomIdsInCategory(categoryId int64) ([]int64, error) {
+	query := "select id from rooms where category_id = $1 order by id"
+	rows, err := tx.tx.Query(query, categoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	roomIds := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		roomIds = append(roomIds, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return roomIds, nil
+}
+
+// GetRoomIdsOfScheduleGroup returns rooms the group has schedules in, and the
+// room of the group itself.
+func (tx *Tx) GetRoomIdsOfScheduleGroup(groupId int64) ([]int64, error) {
+	query := `
+select room_id from schedule_groups where id = $1
+union
+select room_id from schedules where schedule_group_id = $1
+order by room_id
+`
+	rows, err := tx.tx.Query(query, groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	roomIds := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		roomIds = append(roomIds, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return roomIds, nil
+}
+
 func (tx *Tx) AddRoom(room *types.Room) error {
 	if room == nil {
 		return errors.New("room is nil")
 	}
-	query := "insert into rooms (name, seats, category_id) values ($1, $2, $3) returning id"
-	row := tx.tx.QueryRow(query, room.Name, room.Seats, room.CategoryId)
+	query := `
+insert into rooms (name, seats, category_id, requires_approval, shared, requires_check_in, buffer_before, buffer_after)
+values ($1, $2, $3, $4, $5, $6, $7, $8)
+returning id
+`
+	row := tx.tx.QueryRow(query, room.Name, room.Seats, room.CategoryId, room.RequiresApproval, room.Shared, room.RequiresCheckIn, room.BufferBefore, room.BufferAfter)
 	var id int64
-	if err := row.Scan(&id); err != nil {
+	if err := row.Scan(&id); isUniqueViolation(err) {
+		return ErrDuplicateName
+	} else if err != nil {
 		return err
 	}
 	room.Id = id
 	return nil
 }
 
+// UpdateRoom changes the name, seats and category of the room, keeping its
+// schedules. CategoryId -1 removes the room from its category.
+func (tx *Tx) UpdateRoom(room *types.Room) error {
+	if room == nil {
+		return errors.New("room is nil")
+	}
+	query := "update rooms set name = $2, seats = $3, category_id = nullif($4::bigint, -1) where id = $1"
+	res, err := tx.tx.Exec(query, room.Id, room.Name, room.Seats, room.CategoryId)
+	if isUniqueViolation(err) {
+		return ErrDuplicateName
+	} else if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// SetRoomArchived archives or restores the room. Schedules of the room are
+// kept either way.
+func (tx *Tx) SetRoomArchived(roomId int64, archived bool) error {
+	query := "update rooms set archived = $2 where id = $1"
+	res, err := tx.tx.Exec(query, roomId, archived)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// GetUpcomingScheduleGroupsOfRoom returns schedule groups with a schedule in
+// the room which has not ended yet.
+func (tx *Tx) GetUpcomingScheduleGroupsOfRoom(roomId int64) ([]*types.ScheduleGroup, error) {
+	query := `
+select distinct schedule_group_id
+from schedules
+where room_id = $1 and upper(during) > now()
+order by schedule_group_id
+`
+	rows, err := tx.tx.Query(query, roomId)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIds := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		groupIds = append(groupIds, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	groups := []*types.ScheduleGroup{}
+	for _, id := range groupIds {
+		group, err := tx.GetScheduleGroupById(id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// DeleteRoom deletes the room together with its schedules. Schedule groups
+// booking other rooms as well are moved to one of the other rooms first, so
+// that only their schedules in the deleted room are removed.
 func (tx *Tx) DeleteRoom(roomId int64) error {
-	query := "delete from rooms where id = $1"
+	query := `
+update schedule_groups sg
+set room_id = (select min(s.room_id) from schedules s where s.schedule_group_id = sg.id and s.room_id <> $1)
+where sg.room_id = $1 and exists (select 1 from schedules s where s.schedule_group_id = sg.id and s.room_id <> $1)
+`
+	if _, err := tx.tx.Exec(query, roomId); err != nil {
+		return err
+	}
+
+	query = "delete from rooms where id = $1"
 	res, err := tx.tx.Exec(query, roomId)
 	if err != nil {
 		return err
@@ -233,28 +495,49 @@ func (tx *Tx) DeleteRoom(roomId int64) error {
 }
 
 func (tx *Tx) GetScheduleGroupById(id int64) (*types.ScheduleGroup, error) {
-	query := "select room_id, user_idx, reservee, email, phone_number, reason from schedule_groups where id = $1"
+	query := `
+select room_id, user_idx, reservee, email, phone_number, reason, rrule, exdates, timezone, status, status_reason, coalesce(extract(epoch from hold_expires_at)::bigint, 0), no_show_count
+from schedule_groups
+where id = $1
+`
 	row := tx.tx.QueryRow(query, id)
 
 	var (
-		roomId      int64
-		userIdx     int64
-		reservee    string
-		email       string
-		phoneNumber string
-		reason      string
+		roomId       int64
+		userIdx      int64
+		reservee     string
+		email        string
+		phoneNumber  string
+		reason       string
+		rrule        string
+		exDates      pq.Int64Array
+		timezone     string
+		status       string
+		statusReason string
+		holdExpires  int64
+		noShowCount  int
 	)
-	if err := row.Scan(&roomId, &userIdx, &reservee, &email, &phoneNumber, &reason); err != nil {
+	if err := row.Scan(&roomId, &userIdx, &reservee, &email, &phoneNumber, &reason, &rrule, &exDates, &timezone, &status, &statusReason, &holdExpires, &noShowCount); err != nil {
 		return nil, err
 	}
+	if exDates == nil {
+		exDates = pq.Int64Array{}
+	}
 	sg := &types.ScheduleGroup{
-		Id:          id,
-		RoomId:      roomId,
-		UserIdx:     userIdx,
-		Reservee:    reservee,
-		Email:       email,
-		PhoneNumber: phoneNumber,
-		Reason:      reason,
+		Id:            id,
+		RoomId:        roomId,
+		UserIdx:       userIdx,
+		Reservee:      reservee,
+		Email:         email,
+		PhoneNumber:   phoneNumber,
+		Reason:        reason,
+		RRule:         rrule,
+		ExDates:       []int64(exDates),
+		Timezone:      timezone,
+		Status:        status,
+		StatusReason:  statusReason,
+		HoldExpiresAt: holdExpires,
+		NoShowCount:   noShowCount,
 	}
 	return sg, nil
 }
@@ -263,8 +546,19 @@ func (tx *Tx) AddScheduleGroup(group *types.ScheduleGroup) error {
 	if group == nil {
 		return errors.New("group is nil")
 	}
-	query := "insert into schedule_groups (room_id, user_idx, reservee, email, phone_number, reason) values ($1, $2, $3, $4, $5, $6) returning id"
-	row := tx.tx.QueryRow(query, group.RoomId, group.UserIdx, group.Reservee, group.Email, group.PhoneNumber, group.Reason)
+	exDates := group.ExDates
+	if exDates == nil {
+		exDates = []int64{}
+	}
+	if group.Status == "" {
+		group.Status = types.ScheduleGroupStatusApproved
+	}
+	query := `
+insert into schedule_groups (room_id, user_idx, reservee, email, phone_number, reason, rrule, exdates, timezone, status, hold_expires_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, to_timestamp(nullif($11::bigint, 0)))
+returning id
+`
+	row := tx.tx.QueryRow(query, group.RoomId, group.UserIdx, group.Reservee, group.Email, group.PhoneNumber, group.Reason, group.RRule, pq.Array(exDates), group.Timezone, group.Status, group.HoldExpiresAt)
 	var id int64
 	if err := row.Scan(&id); err != nil {
 		return err
@@ -273,8 +567,75 @@ func (tx *Tx) AddScheduleGroup(group *types.ScheduleGroup) error {
 	return nil
 }
 
+func (tx *Tx) UpdateScheduleGroup(group *types.ScheduleGroup) error {
+	if group == nil {
+		return errors.New("group is nil")
+	}
+	query := "update schedule_groups set reservee = $2, email = $3, phone_number = $4, reason = $5 where id = $1"
+	res, err := tx.tx.Exec(query, group.Id, group.Reservee, group.Email, group.PhoneNumber, group.Reason)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// AddScheduleGroupExDates excludes more occurrences from the recurrence rule of
+// the group.
+func (tx *Tx) AddScheduleGroupExDates(groupId int64, exDates []int64) error {
+	query := "update schedule_groups set exdates = array(select distinct unnest(exdates || $2::bigint[]) order by 1) where id = $1"
+	res, err := tx.tx.Exec(query, groupId, pq.Int64Array(exDates))
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// SetScheduleGroupRecurrence replaces the recurrence rule of the group and the
+// occurrences excluded from it.
+func (tx *Tx) SetScheduleGroupRecurrence(groupId int64, rrule string, exDates []int64) error {
+	if exDates == nil {
+		exDates = []int64{}
+	}
+	query := "update schedule_groups set rrule = $2, exdates = $3 where id = $1"
+	res, err := tx.tx.Exec(query, groupId, rrule, pq.Array(exDates))
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// MoveSchedulesToGroup makes the schedules part of another group.
+func (tx *Tx) MoveSchedulesToGroup(scheduleIds []int64, groupId int64) error {
+	query := "update schedules set schedule_group_id = $2 where id = any($1)"
+	res, err := tx.tx.Exec(query, pq.Array(scheduleIds), groupId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected < int64(len(scheduleIds)) {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
 func (tx *Tx) DeleteScheduleGroup(groupId int64) error {
-	query := "delete from groups where id = $1"
+	query := "delete from schedule_groups where id = $1"
 	res, err := tx.tx.Exec(query, groupId)
 	if err != nil {
 		return err
@@ -292,7 +653,7 @@ func (tx *Tx) GetSchedules(roomId int64, startTimestamp int64, endTimestamp int6
 		return nil, errors.New("invalid time range")
 	}
 	query := `
-select s.id, s.room_id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint
+select s.id, s.room_id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint, sg.status, s.seats, coalesce(s.seat, 0)
 from schedules s
 inner join schedule_groups sg on (s.schedule_group_id = sg.id)
 where s.room_id = $1 and s.during <@ tstzrange(to_timestamp($2), to_timestamp($3), '[)')
@@ -311,8 +672,11 @@ where s.room_id = $1 and s.during <@ tstzrange(to_timestamp($2), to_timestamp($3
 			reservee        string
 			startTimestamp  int64
 			endTimestamp    int64
+			status          string
+			seats           int
+			seat            int
 		)
-		if err := rows.Scan(&id, &roomId, &scheduleGroupId, &reservee, &startTimestamp, &endTimestamp); err != nil {
+		if err := rows.Scan(&id, &roomId, &scheduleGroupId, &reservee, &startTimestamp, &endTimestamp, &status, &seats, &seat); err != nil {
 			if err := rows.Close(); err != nil {
 				return nil, err
 			}
@@ -325,6 +689,66 @@ where s.room_id = $1 and s.during <@ tstzrange(to_timestamp($2), to_timestamp($3
 			Reservee:        reservee,
 			StartTimestamp:  startTimestamp,
 			EndTimestamp:    endTimestamp,
+			Status:          status,
+			Seats:           seats,
+			Seat:            seat,
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// GetSchedulesOfRooms is GetSchedules over several rooms, ordered by room id
+// and start time.
+func (tx *Tx) GetSchedulesOfRooms(roomIds []int64, startTimestamp int64, endTimestamp int64) ([]*types.Schedule, error) {
+	if endTimestamp <= startTimestamp {
+		return nil, errors.New("invalid time range")
+	}
+	query := `
+select s.id, s.room_id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint, sg.status, s.seats, coalesce(s.seat, 0)
+from schedules s
+inner join schedule_groups sg on (s.schedule_group_id = sg.id)
+where s.room_id = any($1) and s.during <@ tstzrange(to_timestamp($2), to_timestamp($3), '[)')
+order by s.room_id, lower(s.during)
+`
+	rows, err := tx.tx.Query(query, pq.Array(roomIds), startTimestamp, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	for rows.Next() {
+		var (
+			id              int64
+			roomId          int64
+			scheduleGroupId int64
+			reservee        string
+			startTimestamp  int64
+			endTimestamp    int64
+			status          string
+			seats           int
+			seat            int
+		)
+		if err := rows.Scan(&id, &roomId, &scheduleGroupId, &reservee, &startTimestamp, &endTimestamp, &status, &seats, &seat); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedule := &types.Schedule{
+			Id:              id,
+			RoomId:          roomId,
+			ScheduleGroupId: scheduleGroupId,
+			Reservee:        reservee,
+			StartTimestamp:  startTimestamp,
+			EndTimestamp:    endTimestamp,
+			Status:          status,
+			Seats:           seats,
+			Seat:            seat,
 		}
 		schedules = append(schedules, schedule)
 	}
@@ -336,7 +760,7 @@ where s.room_id = $1 and s.during <@ tstzrange(to_timestamp($2), to_timestamp($3
 }
 
 func (tx *Tx) GetScheduleById(id int64) (*types.Schedule, error) {
-	query := "select room_id, schedule_group_id, extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint from schedules where id = $1"
+	query := "select room_id, schedule_group_id, extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint, seats, coalesce(seat, 0) from schedules where id = $1"
 	row := tx.tx.QueryRow(query, id)
 
 	var (
@@ -344,8 +768,10 @@ func (tx *Tx) GetScheduleById(id int64) (*types.Schedule, error) {
 		scheduleGroupId int64
 		startTimestamp  int64
 		endTimestamp    int64
+		seats           int
+		seat            int
 	)
-	if err := row.Scan(&roomId, &scheduleGroupId, &startTimestamp, &endTimestamp); err != nil {
+	if err := row.Scan(&roomId, &scheduleGroupId, &startTimestamp, &endTimestamp, &seats, &seat); err != nil {
 		return nil, err
 	}
 	schedule := &types.Schedule{
@@ -354,6 +780,8 @@ func (tx *Tx) GetScheduleById(id int64) (*types.Schedule, error) {
 		ScheduleGroupId: scheduleGroupId,
 		StartTimestamp:  startTimestamp,
 		EndTimestamp:    endTimestamp,
+		Seats:           seats,
+		Seat:            seat,
 	}
 	return schedule, nil
 }
@@ -362,10 +790,39 @@ func (tx *Tx) AddSchedule(schedule *types.Schedule) error {
 	if schedule == nil {
 		return errors.New("schedule is nil")
 	}
-	query := "insert into schedules (room_id, schedule_group_id, during) values ($1, $2, tstzrange(to_timestamp($3), to_timestamp($4), '[)')) returning id"
-	row := tx.tx.QueryRow(query, schedule.RoomId, schedule.ScheduleGroupId, schedule.StartTimestamp, schedule.EndTimestamp)
+	shared, capacity, err := tx.lockSharedRoom(schedule.RoomId)
+	if err != nil {
+		return err
+	}
+	if err := checkSeatRequest(schedule, shared, capacity); err != nil {
+		return err
+	}
+	if shared {
+		if err := tx.checkSeatCapacity(schedule, capacity); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.tx.Exec("savepoint add_schedule"); err != nil {
+		return err
+	}
+	query := `
+insert into schedules (room_id, schedule_group_id, during, blocked, seats, seat)
+select r.id, $2, tstzrange(to_timestamp($3), to_timestamp($4), '[)'),
+	tstzrange(to_timestamp($3) - make_interval(secs => r.buffer_before), to_timestamp($4) + make_interval(secs => r.buffer_after), '[)'),
+	$5, nullif($6::integer, 0)
+from rooms r
+where r.id = $1
+returning id
+`
+	row := tx.tx.QueryRow(query, schedule.RoomId, schedule.ScheduleGroupId, schedule.StartTimestamp, schedule.EndTimestamp, schedule.Seats, schedule.Seat)
 	var id int64
 	if err := row.Scan(&id); err != nil {
+		if isExclusionViolation(err) {
+			return tx.rollbackConflict("add_schedule", []*types.Schedule{schedule})
+		}
+		return err
+	}
+	if _, err := tx.tx.Exec("release savepoint add_schedule"); err != nil {
 		return err
 	}
 	schedule.Id = id
@@ -385,3 +842,285 @@ func (tx *Tx) DeleteSchedule(id int64) error {
 	}
 	return nil
 }
+
+// GetSchedulesInGroup returns schedules of the group starting at or after
+// fromTimestamp, ordered by start time.
+func (tx *Tx) GetSchedulesInGroup(groupId int64, fromTimestamp int64) ([]*types.Schedule, error) {
+	query := `
+select id, room_id, extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint, seats, coalesce(seat, 0)
+from schedules
+where schedule_group_id = $1 and lower(during) >= to_timestamp($2)
+order by lower(during)
+`
+	rows, err := tx.tx.Query(query, groupId, fromTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	for rows.Next() {
+		var (
+			id             int64
+			roomId         int64
+			startTimestamp int64
+			endTimestamp   int64
+			seats          int
+			seat           int
+		)
+		if err := rows.Scan(&id, &roomId, &startTimestamp, &endTimestamp, &seats, &seat); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedule := &types.Schedule{
+			Id:              id,
+			RoomId:          roomId,
+			ScheduleGroupId: groupId,
+			StartTimestamp:  startTimestamp,
+			EndTimestamp:    endTimestamp,
+			Seats:           seats,
+			Seat:            seat,
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// UpdateSchedules moves the given schedules to their new time ranges, keeping
+// the buffers of the room around them. The overlap constraints and seat
+// capacity are checked once after every schedule is moved, so that schedules
+// may be shifted onto the previous slots of each other.
+func (tx *Tx) UpdateSchedules(schedules []*types.Schedule) error {
+	if _, err := tx.tx.Exec("savepoint update_schedules"); err != nil {
+		return err
+	}
+	if _, err := tx.tx.Exec("set constraints schedules_blocked_excl, schedules_seat_excl deferred"); err != nil {
+		return err
+	}
+	query := `
+update schedules s
+set during = tstzrange(to_timestamp($2), to_timestamp($3), '[)'),
+	blocked = tstzrange(to_timestamp($2) - make_interval(secs => r.buffer_before), to_timestamp($3) + make_interval(secs => r.buffer_after), '[)')
+from rooms r
+where s.id = $1 and r.id = s.room_id
+returning s.room_id, s.seats
+`
+	for _, schedule := range schedules {
+		if err := tx.tx.QueryRow(query, schedule.Id, schedule.StartTimestamp, schedule.EndTimestamp).Scan(&schedule.RoomId, &schedule.Seats); err == sql.ErrNoRows {
+			return ErrNoRowAffected
+		} else if err != nil {
+			return err
+		}
+	}
+	for _, schedule := range schedules {
+		shared, capacity, err := tx.lockSharedRoom(schedule.RoomId)
+		if err != nil {
+			return err
+		}
+		if !shared {
+			continue
+		}
+		var conflictErr *ConflictError
+		if err := tx.checkSeatCapacity(schedule, capacity); errors.As(err, &conflictErr) {
+			if _, err := tx.tx.Exec("rollback to savepoint update_schedules"); err != nil {
+				return err
+			}
+			return conflictErr
+		} else if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.tx.Exec("set constraints schedules_blocked_excl, schedules_seat_excl immediate"); err != nil {
+		if isExclusionViolation(err) {
+			return tx.rollbackConflict("update_schedules", schedules)
+		}
+		return err
+	}
+	if _, err := tx.tx.Exec("release savepoint update_schedules"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetOverlappingSchedules returns schedules of the room which overlap the given
+// time range, including the buffers of the room on both sides.
+func (tx *Tx) GetOverlappingSchedules(roomId int64, startTimestamp int64, endTimestamp int64) ([]*types.Schedule, error) {
+	query := `
+select s.id, s.schedule_group_id, sg.reservee, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint, sg.status, s.seats, coalesce(s.seat, 0)
+from schedules s
+inner join schedule_groups sg on (s.schedule_group_id = sg.id)
+inner join rooms r on (s.room_id = r.id)
+where s.room_id = $1
+	and s.blocked && tstzrange(to_timestamp($2) - make_interval(secs => r.buffer_before), to_timestamp($3) + make_interval(secs => r.buffer_after), '[)')
+order by lower(s.during)
+`
+	rows, err := tx.tx.Query(query, roomId, startTimestamp, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	for rows.Next() {
+		var (
+			id              int64
+			scheduleGroupId int64
+			reservee        string
+			startTimestamp  int64
+			endTimestamp    int64
+			status          string
+			seats           int
+			seat            int
+		)
+		if err := rows.Scan(&id, &scheduleGroupId, &reservee, &startTimestamp, &endTimestamp, &status, &seats, &seat); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedule := &types.Schedule{
+			Id:              id,
+			RoomId:          roomId,
+			ScheduleGroupId: scheduleGroupId,
+			Reservee:        reservee,
+			StartTimestamp:  startTimestamp,
+			EndTimestamp:    endTimestamp,
+			Status:          status,
+			Seats:           seats,
+			Seat:            seat,
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// rollbackConflict rolls back to the savepoint taken before the given schedules
+// were written, and returns ConflictError with schedules overlapping them. If
+// the written schedules overlap each other, they are returned as well.
+func (tx *Tx) rollbackConflict(savepoint string, schedules []*types.Schedule) error {
+	if _, err := tx.tx.Exec("rollback to savepoint " + savepoint); err != nil {
+		return err
+	}
+	reported := map[int64]bool{}
+	for _, schedule := range schedules {
+		reported[schedule.Id] = true
+	}
+	conflicts := []*types.Schedule{}
+	for _, schedule := range schedules {
+		overlapping, err := tx.GetOverlappingSchedules(schedule.RoomId, schedule.StartTimestamp, schedule.EndTimestamp)
+		if err != nil {
+			return err
+		}
+		for _, o := range overlapping {
+			if !reported[o.Id] {
+				reported[o.Id] = true
+				conflicts = append(conflicts, o)
+			}
+		}
+	}
+
+	collided := map[int64]bool{}
+	for i, a := range schedules {
+		before, after, err := tx.roomBuffers(a.RoomId)
+		if err != nil {
+			return err
+		}
+		for _, b := range schedules[i+1:] {
+			if a.RoomId != b.RoomId {
+				continue
+			}
+			overlaps := a.StartTimestamp-before < b.EndTimestamp+after && b.StartTimestamp-before < a.EndTimestamp+after
+			sameSeat := (a.Seats == 0 && b.Seats == 0) || (a.Seat != 0 && a.Seat == b.Seat)
+			if overlaps && sameSeat {
+				for _, s := range []*types.Schedule{a, b} {
+					if !collided[s.Id] {
+						collided[s.Id] = true
+						conflicts = append(conflicts, s)
+					}
+				}
+			}
+		}
+	}
+	return &ConflictError{Schedules: conflicts}
+}
+
+func isExclusionViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "exclusion_violation"
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation"
+}
+
+func (tx *Tx) GetPendingScheduleGroups() ([]*types.ScheduleGroup, error) {
+	query := "select id from schedule_groups where status = $1 order by id"
+	rows, err := tx.tx.Query(query, types.ScheduleGroupStatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIds := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		groupIds = append(groupIds, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	groups := []*types.ScheduleGroup{}
+	for _, id := range groupIds {
+		group, err := tx.GetScheduleGroupById(id)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// ReviewScheduleGroup moves a pending schedule group to the given status.
+// Schedules of a rejected group are deleted so that the slots are freed.
+func (tx *Tx) ReviewScheduleGroup(groupId int64, status string, reason string) error {
+	query := "update schedule_groups set status = $2, status_reason = $3 where id = $1 and status = $4"
+	res, err := tx.tx.Exec(query, groupId, status, reason, types.ScheduleGroupStatusPending)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+
+	if status == types.ScheduleGroupStatusRejected {
+		if _, err := tx.tx.Exec("delete from schedules where schedule_group_id = $1", groupId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetScheduleGroupApproval sets an approved group back to pending, so that
+// it is reviewed again. Groups which are not approved are left as they are.
+func (tx *Tx) ResetScheduleGroupApproval(groupId int64) error {
+	query := "update schedule_groups set status = $2, status_reason = '' where id = $1 and status = $3"
+	_, err := tx.tx.Exec(query, groupId, types.ScheduleGroupStatusPending, types.ScheduleGroupStatusApproved)
+	return err
+}
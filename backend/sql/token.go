@@ -0,0 +1,102 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+)
+
+const apiTokenColumns = `
+id, user_idx, username, name, scope,
+extract(epoch from created_at)::bigint, extract(epoch from expires_at)::bigint, extract(epoch from last_used_at)::bigint,
+revoked
+`
+
+func scanAPIToken(row rowScanner) (*types.APIToken, error) {
+	var (
+		token    types.APIToken
+		lastUsed sql.NullInt64
+	)
+	err := row.Scan(
+		&token.Id, &token.UserIdx, &token.Username, &token.Name, &token.Scope,
+		&token.CreatedTimestamp, &token.ExpireTimestamp, &lastUsed,
+		&token.Revoked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	token.LastUsedTimestamp = -1
+	if lastUsed.Valid {
+		token.LastUsedTimestamp = lastUsed.Int64
+	}
+	return &token, nil
+}
+
+// AddAPIToken stores the token with the hash of its secret.
+func (tx *Tx) AddAPIToken(token *types.APIToken, hash string) error {
+	if token == nil {
+		return errors.New("token is nil")
+	}
+	query := `
+insert into api_tokens (user_idx, username, name, scope, token_hash, expires_at)
+values ($1, $2, $3, $4, $5, to_timestamp($6))
+returning ` + apiTokenColumns
+	row := tx.tx.QueryRow(query, token.UserIdx, token.Username, token.Name, token.Scope, hash, token.ExpireTimestamp)
+	added, err := scanAPIToken(row)
+	if err != nil {
+		return err
+	}
+	*token = *added
+	return nil
+}
+
+func (tx *Tx) GetAPITokensOfUser(userIdx int64) ([]*types.APIToken, error) {
+	query := "select " + apiTokenColumns + " from api_tokens where user_idx = $1 order by id"
+	rows, err := tx.tx.Query(query, userIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []*types.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// UseAPIToken returns the token with the hash and records that it was used.
+// sql.ErrNoRows is returned if no such token is valid.
+func (tx *Tx) UseAPIToken(hash string) (*types.APIToken, error) {
+	query := `
+update api_tokens set last_used_at = now()
+where token_hash = $1 and not revoked and expires_at > now()
+returning ` + apiTokenColumns
+	return scanAPIToken(tx.tx.QueryRow(query, hash))
+}
+
+// RevokeAPIToken revokes the token if it belongs to the user.
+func (tx *Tx) RevokeAPIToken(tokenId int64, userIdx int64) error {
+	query := "update api_tokens set revoked = true where id = $1 and user_idx = $2 and not revoked"
+	res, err := tx.tx.Exec(query, tokenId, userIdx)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
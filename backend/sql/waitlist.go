@@ -0,0 +1,218 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/bacchus-snu/reservation/types"
+	"github.com/lib/pq"
+)
+
+const waitlistEntryColumns = `
+id, room_id, user_idx, reservee, email, phone_number, reason,
+extract(epoch from lower(during))::bigint, extract(epoch from upper(during))::bigint,
+seats, promotion, status, schedule_group_id, extract(epoch from created_at)::bigint
+`
+
+func scanWaitlistEntry(row rowScanner) (*types.WaitlistEntry, error) {
+	var (
+		entry           types.WaitlistEntry
+		scheduleGroupId sql.NullInt64
+	)
+	err := row.Scan(&entry.Id, &entry.RoomId, &entry.UserIdx, &entry.Reservee, &entry.Email, &entry.PhoneNumber, &entry.Reason,
+		&entry.StartTimestamp, &entry.EndTimestamp, &entry.Seats, &entry.Promotion, &entry.Status, &scheduleGroupId, &entry.CreatedTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if scheduleGroupId.Valid {
+		entry.ScheduleGroupId = scheduleGroupId.Int64
+	} else {
+		entry.ScheduleGroupId = -1
+	}
+	return &entry, nil
+}
+
+func (tx *Tx) queryWaitlistEntries(query string, args ...interface{}) ([]*types.WaitlistEntry, error) {
+	rows, err := tx.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*types.WaitlistEntry{}
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (tx *Tx) AddWaitlistEntry(entry *types.WaitlistEntry) error {
+	if entry == nil {
+		return errors.New("entry is nil")
+	}
+	if entry.Promotion == "" {
+		entry.Promotion = types.WaitlistPromotionHold
+	}
+	query := `
+insert into waitlist_entries (room_id, user_idx, reservee, email, phone_number, reason, during, seats, promotion)
+values ($1, $2, $3, $4, $5, $6, tstzrange(to_timestamp($7), to_timestamp($8), '[)'), $9, $10)
+returning id, status, extract(epoch from created_at)::bigint
+`
+	row := tx.tx.QueryRow(query, entry.RoomId, entry.UserIdx, entry.Reservee, entry.Email, entry.PhoneNumber, entry.Reason,
+		entry.StartTimestamp, entry.EndTimestamp, entry.Seats, entry.Promotion)
+	if err := row.Scan(&entry.Id, &entry.Status, &entry.CreatedTimestamp); err != nil {
+		return err
+	}
+	entry.ScheduleGroupId = -1
+	return nil
+}
+
+func (tx *Tx) GetWaitlistEntryById(id int64) (*types.WaitlistEntry, error) {
+	query := "select " + waitlistEntryColumns + " from waitlist_entries where id = $1"
+	return scanWaitlistEntry(tx.tx.QueryRow(query, id))
+}
+
+// GetWaitlistEntriesOfUser returns entries of the user, newest first.
+func (tx *Tx) GetWaitlistEntriesOfUser(userIdx int64) ([]*types.WaitlistEntry, error) {
+	query := "select " + waitlistEntryColumns + " from waitlist_entries where user_idx = $1 order by id desc"
+	return tx.queryWaitlistEntries(query, userIdx)
+}
+
+// GetWaitingEntries returns waiting entries of the room which overlap the
+// given time range or the buffers of the room around it, and have not started
+// yet, in the order they joined. The entries are locked until the end of the
+// transaction.
+func (tx *Tx) GetWaitingEntries(roomId int64, startTimestamp int64, endTimestamp int64) ([]*types.WaitlistEntry, error) {
+	query := "select " + waitlistEntryColumns + `
+from waitlist_entries
+where room_id = $1 and status = $4 and lower(during) > now()
+	and during && (
+		select tstzrange(to_timestamp($2) - make_interval(secs => r.buffer_after), to_timestamp($3) + make_interval(secs => r.buffer_before), '[)')
+		from rooms r
+		where r.id = $1
+	)
+order by created_at, id
+for update
+`
+	return tx.queryWaitlistEntries(query, roomId, startTimestamp, endTimestamp, types.WaitlistStatusWaiting)
+}
+
+// SetWaitlistEntryStatus records the status of the entry and the schedule
+// group created by promoting it, -1 if none.
+func (tx *Tx) SetWaitlistEntryStatus(id int64, status string, scheduleGroupId int64) error {
+	query := "update waitlist_entries set status = $2, schedule_group_id = nullif($3::bigint, -1) where id = $1"
+	res, err := tx.tx.Exec(query, id, status, scheduleGroupId)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+func (tx *Tx) DeleteWaitlistEntry(id int64) error {
+	query := "delete from waitlist_entries where id = $1"
+	res, err := tx.tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// ExpireWaitlistEntries expires waiting entries which have already started.
+func (tx *Tx) ExpireWaitlistEntries() error {
+	query := "update waitlist_entries set status = $2 where status = $1 and lower(during) <= now()"
+	_, err := tx.tx.Exec(query, types.WaitlistStatusWaiting, types.WaitlistStatusExpired)
+	return err
+}
+
+// ExpireWaitlistEntriesOfRoom expires waiting entries of the room and returns
+// them.
+func (tx *Tx) ExpireWaitlistEntriesOfRoom(roomId int64) ([]*types.WaitlistEntry, error) {
+	query := "update waitlist_entries set status = $3 where room_id = $1 and status = $2 returning " + waitlistEntryColumns
+	return tx.queryWaitlistEntries(query, roomId, types.WaitlistStatusWaiting, types.WaitlistStatusExpired)
+}
+
+// ConfirmHeldScheduleGroup changes the status of a held group which has not
+// expired yet to the given status.
+func (tx *Tx) ConfirmHeldScheduleGroup(groupId int64, status string) error {
+	query := "update schedule_groups set status = $3, hold_expires_at = null where id = $1 and status = $2 and hold_expires_at > now()"
+	res, err := tx.tx.Exec(query, groupId, types.ScheduleGroupStatusHeld, status)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected <= 0 {
+		return ErrNoRowAffected
+	}
+	return nil
+}
+
+// ReleaseExpiredHolds deletes held groups which were not confirmed in time and
+// returns their schedules. Waitlist entries offered the groups are expired.
+func (tx *Tx) ReleaseExpiredHolds() ([]*types.Schedule, error) {
+	query := `
+select sg.id, s.id, s.room_id, extract(epoch from lower(s.during))::bigint, extract(epoch from upper(s.during))::bigint
+from schedule_groups sg
+inner join schedules s on (s.schedule_group_id = sg.id)
+where sg.status = $1 and sg.hold_expires_at <= now()
+order by s.room_id, lower(s.during)
+for update of sg
+`
+	rows, err := tx.tx.Query(query, types.ScheduleGroupStatusHeld)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []*types.Schedule{}
+	groupIds := []int64{}
+	seen := map[int64]bool{}
+	for rows.Next() {
+		var schedule types.Schedule
+		if err := rows.Scan(&schedule.ScheduleGroupId, &schedule.Id, &schedule.RoomId, &schedule.StartTimestamp, &schedule.EndTimestamp); err != nil {
+			if err := rows.Close(); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		schedules = append(schedules, &schedule)
+		if !seen[schedule.ScheduleGroupId] {
+			seen[schedule.ScheduleGroupId] = true
+			groupIds = append(groupIds, schedule.ScheduleGroupId)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if len(groupIds) == 0 {
+		return schedules, nil
+	}
+
+	query = "update waitlist_entries set status = $2 where schedule_group_id = any($1) and status = $3"
+	if _, err := tx.tx.Exec(query, pq.Array(groupIds), types.WaitlistStatusExpired, types.WaitlistStatusOffered); err != nil {
+		return nil, err
+	}
+	if _, err := tx.tx.Exec("delete from schedule_groups where id = any($1)", pq.Array(groupIds)); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
@@ -8,17 +8,39 @@ create table if not exists rooms (
     id bigserial primary key,
     name text not null unique check (name <> ''),
     seats integer not null,
-    category_id bigint references categories(id) on delete set null
+    category_id bigint references categories(id) on delete set null,
+    requires_approval boolean not null default false,
+    -- shared rooms are booked by seats instead of as a whole
+    shared boolean not null default false,
+    -- bookings nobody checks in to are released after the grace period
+    requires_check_in boolean not null default false,
+    -- sha-256 of the token kiosks of the room check in with
+    kiosk_token_hash text not null default '',
+    -- seconds kept free before and after each booking, for setup and cleanup
+    buffer_before bigint not null default 0 check (buffer_before >= 0),
+    buffer_after bigint not null default 0 check (buffer_after >= 0),
+    -- archived rooms keep their schedules but cannot be booked
+    archived boolean not null default false
 );
 
 create table if not exists schedule_groups (
     id bigserial primary key,
     room_id bigint not null references rooms(id) on delete cascade,
     user_idx bigint not null,
-    reservee text not null check (reservee <> ''),
-    email text not null check (email <> ''),
-    phone_number text not null check (phone_number <> ''),
-    reason text not null check (reason <> '')
+    -- details are filled in when a hold is confirmed
+    reservee text not null check (status = 'held' or reservee <> ''),
+    email text not null check (status = 'held' or email <> ''),
+    phone_number text not null check (status = 'held' or phone_number <> ''),
+    reason text not null check (status = 'held' or reason <> ''),
+    rrule text not null default '',
+    exdates bigint[] not null default '{}',
+    timezone text not null default 'UTC',
+    status text not null default 'approved' check (status in ('held', 'pending', 'approved', 'rejected')),
+    status_reason text not null default '',
+    -- held groups are released unless confirmed by this time
+    hold_expires_at timestamptz check ((status = 'held') = (hold_expires_at is not null)),
+    -- schedules released because nobody checked in
+    no_show_count integer not null default 0
 );
 
 create extension if not exists btree_gist;
@@ -27,7 +49,237 @@ create table if not exists schedules (
     room_id bigint not null references rooms(id) on delete cascade,
     schedule_group_id bigint not null references schedule_groups(id) on delete cascade,
     during tstzrange not null,
+    -- during extended by the buffers of the room, which bookings must not overlap
+    blocked tstzrange not null,
+    -- seats taken in a shared room, 0 if the whole room is booked
+    seats integer not null default 0 check (seats >= 0),
+    -- specific seat taken in a shared room
+    seat integer check (seat > 0),
+    checked_in_at timestamptz,
 
-    exclude using gist (room_id with =, during with &&)
+    constraint schedules_blocked_excl exclude using gist (room_id with =, blocked with &&) where (seats = 0) deferrable initially immediate,
+    constraint schedules_seat_excl exclude using gist (room_id with =, seat with =, blocked with &&) where (seat is not null) deferrable initially immediate
 );
 create index if not exists during_idx on schedules using gist (during);
+
+-- bring databases created before the columns above up to date
+alter table rooms
+    add column if not exists requires_approval boolean not null default false,
+    add column if not exists shared boolean not null default false,
+    add column if not exists requires_check_in boolean not null default false,
+    add column if not exists kiosk_token_hash text not null default '',
+    add column if not exists buffer_before bigint not null default 0 check (buffer_before >= 0),
+    add column if not exists buffer_after bigint not null default 0 check (buffer_after >= 0),
+    add column if not exists archived boolean not null default false;
+
+alter table schedule_groups
+    add column if not exists rrule text not null default '',
+    add column if not exists exdates bigint[] not null default '{}',
+    add column if not exists timezone text not null default 'UTC',
+    add column if not exists status text not null default 'approved' check (status in ('held', 'pending', 'approved', 'rejected')),
+    add column if not exists status_reason text not null default '',
+    add column if not exists hold_expires_at timestamptz check ((status = 'held') = (hold_expires_at is not null)),
+    add column if not exists no_show_count integer not null default 0;
+alter table schedule_groups
+    drop constraint if exists schedule_groups_reservee_check,
+    drop constraint if exists schedule_groups_email_check,
+    drop constraint if exists schedule_groups_phone_number_check,
+    drop constraint if exists schedule_groups_reason_check,
+    add constraint schedule_groups_reservee_check check (status = 'held' or reservee <> ''),
+    add constraint schedule_groups_email_check check (status = 'held' or email <> ''),
+    add constraint schedule_groups_phone_number_check check (status = 'held' or phone_number <> ''),
+    add constraint schedule_groups_reason_check check (status = 'held' or reason <> '');
+
+alter table schedules
+    add column if not exists blocked tstzrange,
+    add column if not exists seats integer not null default 0 check (seats >= 0),
+    add column if not exists seat integer check (seat > 0),
+    add column if not exists checked_in_at timestamptz;
+update schedules set blocked = during where blocked is null;
+alter table schedules
+    alter column blocked set not null,
+    drop constraint if exists schedules_room_id_during_excl,
+    drop constraint if exists schedules_during_excl,
+    drop constraint if exists schedules_blocked_excl,
+    drop constraint if exists schedules_seat_excl,
+    add constraint schedules_blocked_excl exclude using gist (room_id with =, blocked with &&) where (seats = 0) deferrable initially immediate,
+    add constraint schedules_seat_excl exclude using gist (room_id with =, seat with =, blocked with &&) where (seat is not null) deferrable initially immediate;
+
+-- each schedule released because nobody checked in, kept after the schedule
+-- group or the room is deleted
+create table if not exists no_shows (
+    id bigserial primary key,
+    user_idx bigint not null,
+    room_id bigint references rooms(id) on delete set null,
+    schedule_group_id bigint references schedule_groups(id) on delete set null,
+    during tstzrange not null,
+    created_at timestamptz not null default now()
+);
+create index if not exists no_shows_user_idx on no_shows (user_idx);
+
+-- exactly one of room_id and category_id is set, room policy overrides category policy
+create table if not exists booking_policies (
+    id bigserial primary key,
+    room_id bigint unique references rooms(id) on delete cascade,
+    category_id bigint unique references categories(id) on delete cascade,
+    -- zero means no limit, durations are in seconds
+    max_duration bigint not null default 0 check (max_duration >= 0),
+    max_days_in_advance integer not null default 0 check (max_days_in_advance >= 0),
+    min_notice bigint not null default 0 check (min_notice >= 0),
+    slot_granularity bigint not null default 0 check (slot_granularity >= 0),
+
+    check ((room_id is null) <> (category_id is null))
+);
+
+-- rooms without opening hours are always open, times are seconds from midnight in the default timezone
+create table if not exists opening_hours (
+    id bigserial primary key,
+    room_id bigint not null references rooms(id) on delete cascade,
+    weekday integer not null check (weekday between 0 and 6),
+    open_time integer not null check (open_time >= 0),
+    close_time integer not null check (close_time > open_time and close_time <= 86400)
+);
+
+create table if not exists closures (
+    id bigserial primary key,
+    room_id bigint references rooms(id) on delete cascade,
+    category_id bigint references categories(id) on delete cascade,
+    during tstzrange not null,
+    -- RFC 5545 rule repeating during, expanded in timezone
+    rrule text not null default '',
+    timezone text not null default 'UTC',
+    reason text not null default '',
+
+    check ((room_id is null) <> (category_id is null))
+);
+
+-- limits applied to each user's bookings in a room or in every room of a category, zero means no limit
+create table if not exists booking_quotas (
+    id bigserial primary key,
+    room_id bigint unique references rooms(id) on delete cascade,
+    category_id bigint unique references categories(id) on delete cascade,
+    max_hours_per_week integer not null default 0 check (max_hours_per_week >= 0),
+    max_future_bookings integer not null default 0 check (max_future_bookings >= 0),
+    max_recurring_groups integer not null default 0 check (max_recurring_groups >= 0),
+
+    check ((room_id is null) <> (category_id is null))
+);
+
+create table if not exists waitlist_entries (
+    id bigserial primary key,
+    room_id bigint not null references rooms(id) on delete cascade,
+    user_idx bigint not null,
+    reservee text not null check (reservee <> ''),
+    email text not null check (email <> ''),
+    phone_number text not null check (phone_number <> ''),
+    reason text not null check (reason <> ''),
+    during tstzrange not null,
+    seats integer not null default 0 check (seats >= 0),
+    -- 'hold' offers the slot as a held group to be confirmed, 'book' books it directly
+    promotion text not null default 'hold' check (promotion in ('hold', 'book')),
+    status text not null default 'waiting' check (status in ('waiting', 'offered', 'booked', 'expired')),
+    schedule_group_id bigint references schedule_groups(id) on delete set null,
+    created_at timestamptz not null default now()
+);
+create index if not exists waitlist_during_idx on waitlist_entries using gist (room_id, during) where (status = 'waiting');
+
+-- roles granted to users in a room, in every room of a category, or globally
+-- if neither room_id nor category_id is set
+create table if not exists role_grants (
+    id bigserial primary key,
+    user_idx bigint not null,
+    role text not null check (role in ('viewer', 'booker', 'room_manager', 'admin')),
+    room_id bigint references rooms(id) on delete cascade,
+    category_id bigint references categories(id) on delete cascade,
+
+    check (room_id is null or category_id is null)
+);
+create unique index if not exists role_grants_unique_idx on role_grants (user_idx, role, coalesce(room_id, -1), coalesce(category_id, -1));
+
+-- personal tokens to call the api with instead of a jwt. only the hash of the
+-- token is kept. tokens carry no claims of the jwt they were issued with, only
+-- roles from role_grants apply to them.
+create table if not exists api_tokens (
+    id bigserial primary key,
+    user_idx bigint not null,
+    username text not null,
+    name text not null check (name <> ''),
+    scope text not null check (scope in ('read', 'book', 'admin')),
+    token_hash text not null unique,
+    created_at timestamptz not null default now(),
+    expires_at timestamptz not null,
+    last_used_at timestamptz,
+    revoked boolean not null default false
+);
+create index if not exists api_tokens_user_idx on api_tokens (user_idx);
+
+-- append-only log of every change to schedules, schedule groups, rooms and
+-- categories. rows are written by triggers, with the actor set on the
+-- transaction by the server; user_idx is null for changes the server makes by
+-- itself, such as releasing expired holds.
+create table if not exists audit_log (
+    id bigserial primary key,
+    created_at timestamptz not null default now(),
+    user_idx bigint,
+    username text not null default '',
+    action text not null check (action in ('add', 'update', 'delete')),
+    entity text not null,
+    entity_id bigint not null,
+    before jsonb,
+    after jsonb,
+    request_id text not null default ''
+);
+create index if not exists audit_log_entity_idx on audit_log (entity, entity_id);
+create index if not exists audit_log_user_idx on audit_log (user_idx);
+
+create or replace function audit_changes() returns trigger as $$
+declare
+    old_row jsonb;
+    new_row jsonb;
+begin
+    -- kiosk tokens are secrets
+    if TG_OP <> 'INSERT' then
+        old_row := to_jsonb(OLD) - 'kiosk_token_hash';
+    end if;
+    if TG_OP <> 'DELETE' then
+        new_row := to_jsonb(NEW) - 'kiosk_token_hash';
+    end if;
+    if old_row = new_row then
+        return null;
+    end if;
+    insert into audit_log (user_idx, username, action, entity, entity_id, before, after, request_id)
+    values (
+        nullif(current_setting('reservation.user_idx', true), '')::bigint,
+        coalesce(current_setting('reservation.username', true), ''),
+        case TG_OP when 'INSERT' then 'add' when 'UPDATE' then 'update' else 'delete' end,
+        TG_TABLE_NAME,
+        (coalesce(new_row, old_row) ->> 'id')::bigint,
+        old_row,
+        new_row,
+        coalesce(current_setting('reservation.request_id', true), '')
+    );
+    return null;
+end;
+$$ language plpgsql;
+
+create or replace function audit_log_append_only() returns trigger as $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+drop trigger if exists audit_log_append_only on audit_log;
+create trigger audit_log_append_only before update or delete on audit_log
+    for each row execute procedure audit_log_append_only();
+drop trigger if exists categories_audit on categories;
+create trigger categories_audit after insert or update or delete on categories
+    for each row execute procedure audit_changes();
+drop trigger if exists rooms_audit on rooms;
+create trigger rooms_audit after insert or update or delete on rooms
+    for each row execute procedure audit_changes();
+drop trigger if exists schedule_groups_audit on schedule_groups;
+create trigger schedule_groups_audit after insert or update or delete on schedule_groups
+    for each row execute procedure audit_changes();
+drop trigger if exists schedules_audit on schedules;
+create trigger schedules_audit after insert or update or delete on schedules
+    for each row execute procedure audit_changes();
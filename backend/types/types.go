@@ -1,5 +1,7 @@
 package types
 
+import "encoding/json"
+
 type Category struct {
 	Id          int64  `json:"id"`
 	Name        string `json:"name"`
@@ -11,16 +13,45 @@ type Room struct {
 	Name       string `json:"name"`
 	Seats      int    `json:"seats"`
 	CategoryId int64  `json:"categoryId"`
+	// bookings are pending until approved, unless made by a manager of the room
+	RequiresApproval bool `json:"requiresApproval"`
+	// shared rooms are booked by seats, up to Seats at the same time
+	Shared bool `json:"shared"`
+	// bookings nobody checks in to are released after the grace period
+	RequiresCheckIn bool `json:"requiresCheckIn"`
+	// seconds kept free before and after each booking
+	BufferBefore int64 `json:"bufferBefore"`
+	BufferAfter  int64 `json:"bufferAfter"`
+	// archived rooms are hidden from booking but keep their schedules
+	Archived bool `json:"archived"`
 }
 
+const (
+	ScheduleGroupStatusHeld     = "held"
+	ScheduleGroupStatusPending  = "pending"
+	ScheduleGroupStatusApproved = "approved"
+	ScheduleGroupStatusRejected = "rejected"
+)
+
 type ScheduleGroup struct {
-	Id          int64  `json:"id"`
-	RoomId      int64  `json:"roomId"`
-	UserIdx     int64  `json:"userIdx"`
-	Reservee    string `json:"reservee"`
-	Email       string `json:"email"`
-	PhoneNumber string `json:"phoneNumber"`
-	Reason      string `json:"reason"`
+	Id          int64   `json:"id"`
+	RoomId      int64   `json:"roomId"`
+	UserIdx     int64   `json:"userIdx"`
+	Reservee    string  `json:"reservee"`
+	Email       string  `json:"email"`
+	PhoneNumber string  `json:"phoneNumber"`
+	Reason      string  `json:"reason"`
+	RRule       string  `json:"rrule"`
+	ExDates     []int64 `json:"exDates"`
+	Timezone    string  `json:"timezone"`
+	// one of ScheduleGroupStatus*
+	Status string `json:"status"`
+	// reason of rejection, or note of approval
+	StatusReason string `json:"statusReason"`
+	// time until which a held group waits for confirmation, 0 if not held
+	HoldExpiresAt int64 `json:"holdExpiresAt"`
+	// schedules released because nobody checked in
+	NoShowCount int `json:"noShowCount"`
 }
 
 type Schedule struct {
@@ -30,12 +61,80 @@ type Schedule struct {
 	Reservee        string `json:"reservee"`
 	StartTimestamp  int64  `json:"startTimestamp"`
 	EndTimestamp    int64  `json:"endTimestamp"`
+	// status of the schedule group
+	Status string `json:"status"`
+	// seats taken in a shared room, 0 if the whole room is booked
+	Seats int `json:"seats"`
+	// specific seat taken in a shared room, 0 if any
+	Seat int `json:"seat"`
+}
+
+// BookingPolicy limits bookings of a room or of every room in a category.
+// Durations are in seconds and zero means no limit.
+type BookingPolicy struct {
+	Id int64 `json:"id"`
+	// -1 if policy of category
+	RoomId int64 `json:"roomId"`
+	// -1 if policy of room
+	CategoryId       int64 `json:"categoryId"`
+	MaxDuration      int64 `json:"maxDuration"`
+	MaxDaysInAdvance int   `json:"maxDaysInAdvance"`
+	MinNotice        int64 `json:"minNotice"`
+	SlotGranularity  int64 `json:"slotGranularity"`
+}
+
+// BookingQuota limits bookings of each user in a room or in every room of a
+// category. Zero means no limit.
+type BookingQuota struct {
+	Id int64 `json:"id"`
+	// -1 if quota of category
+	RoomId int64 `json:"roomId"`
+	// -1 if quota of room
+	CategoryId         int64 `json:"categoryId"`
+	MaxHoursPerWeek    int   `json:"maxHoursPerWeek"`
+	MaxFutureBookings  int   `json:"maxFutureBookings"`
+	MaxRecurringGroups int   `json:"maxRecurringGroups"`
+}
+
+type OpeningHours struct {
+	// 0 for sunday
+	Weekday int `json:"weekday"`
+	// seconds from midnight
+	OpenTime  int `json:"openTime"`
+	CloseTime int `json:"closeTime"`
+}
+
+// Closure is a time range in which a room or every room in a category cannot
+// be booked, optionally repeated by RRule.
+type Closure struct {
+	Id int64 `json:"id"`
+	// -1 if closure of category
+	RoomId int64 `json:"roomId"`
+	// -1 if closure of room
+	CategoryId     int64  `json:"categoryId"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	RRule          string `json:"rrule"`
+	Timezone       string `json:"timezone"`
+	Reason         string `json:"reason"`
+}
+
+type ClosureOccurrence struct {
+	ClosureId      int64  `json:"closureId"`
+	Reason         string `json:"reason"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
 }
 
 type ErrorResp struct {
 	Msg string `json:"msg"`
 }
 
+type ConflictResp struct {
+	Msg       string      `json:"msg"`
+	Conflicts []*Schedule `json:"conflicts"`
+}
+
 type AddScheduleReq struct {
 	RoomId         int64  `json:"roomId"`
 	Reservee       string `json:"reservee"`
@@ -45,6 +144,36 @@ type AddScheduleReq struct {
 	StartTimestamp int64  `json:"startTimestamp"`
 	EndTimestamp   int64  `json:"endTimestamp"`
 	Repeats        int    `json:"repeats"`
+	// RFC 5545 recurrence rule, overrides Repeats if not empty
+	RRule string `json:"rrule"`
+	// start timestamps of occurrences excluded from RRule
+	ExDates []int64 `json:"exDates"`
+	// IANA zone in which repeats are expanded, server default if empty
+	Timezone string `json:"timezone"`
+	// add every occurrence which does not conflict with other schedules
+	// instead of failing as a whole
+	SkipConflicts bool `json:"skipConflicts"`
+	// seats to book in a shared room, a seat if 0
+	Seats int `json:"seats"`
+	// specific seat to book in a shared room, any seat if 0
+	Seat int `json:"seat"`
+	// rooms booked together under one group, overrides RoomId if not empty
+	RoomIds []int64 `json:"roomIds"`
+}
+
+type AddScheduleResp struct {
+	ScheduleGroupId int64               `json:"scheduleGroupId"`
+	Occurrences     []*OccurrenceResult `json:"occurrences"`
+}
+
+type OccurrenceResult struct {
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+	Created        bool  `json:"created"`
+	// id of the created schedule
+	ScheduleId int64 `json:"scheduleId"`
+	// id of the schedule which the occurrence conflicts with
+	ConflictScheduleId int64 `json:"conflictScheduleId"`
 }
 
 type DeleteScheduleReq struct {
@@ -52,18 +181,98 @@ type DeleteScheduleReq struct {
 	DeleteAllInGroup bool  `json:"deleteAllInGroup"`
 }
 
+type UpdateScheduleReq struct {
+	ScheduleId     int64 `json:"scheduleId"`
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+	// shift every following schedule in the group by the same amount
+	UpdateFollowing bool `json:"updateFollowing"`
+}
+
 type GetScheduleReq struct {
 	RoomId         int64 `json:"roomId"`
 	StartTimestamp int64 `json:"startTimestamp"`
 	EndTimestamp   int64 `json:"endTimestamp"`
 }
 
+type GetRoomsScheduleReq struct {
+	RoomIds []int64 `json:"roomIds"`
+	// used instead of RoomIds if not -1
+	CategoryId     int64 `json:"categoryId"`
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+}
+
 type GetScheduleInfoReq struct {
 	ScheduleGroupId int64 `json:"schedule_group_id"`
 }
 
+type UpdateScheduleInfoReq struct {
+	ScheduleGroupId int64  `json:"scheduleGroupId"`
+	Reservee        string `json:"reservee"`
+	Email           string `json:"email"`
+	PhoneNumber     string `json:"phoneNumber"`
+	Reason          string `json:"reason"`
+}
+
+type GetMyScheduleGroupsReq struct {
+	// -1 for every room
+	RoomId int64 `json:"roomId"`
+	// one of "all", "upcoming" and "past"
+	When     string `json:"when"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+}
+
+type MyScheduleGroup struct {
+	ScheduleGroup
+	OccurrenceCount int `json:"occurrenceCount"`
+	// nil if every occurrence has ended
+	NextOccurrence    *TimeRange  `json:"nextOccurrence"`
+	UpcomingSchedules []*Schedule `json:"upcomingSchedules"`
+}
+
+type GetMyScheduleGroupsResp struct {
+	Groups []*MyScheduleGroup `json:"groups"`
+}
+
 type GetScheduleResp struct {
-	Schedules []*Schedule `json:"schedules"`
+	Schedules []*Schedule          `json:"schedules"`
+	Closures  []*ClosureOccurrence `json:"closures"`
+}
+
+type RoomSchedules struct {
+	RoomId    int64                `json:"roomId"`
+	Schedules []*Schedule          `json:"schedules"`
+	Closures  []*ClosureOccurrence `json:"closures"`
+}
+
+type GetRoomsScheduleResp struct {
+	Rooms []*RoomSchedules `json:"rooms"`
+}
+
+type GetAvailabilityReq struct {
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+	// minimum length of free intervals in seconds
+	MinDuration int64 `json:"minDuration"`
+	// -1 for every category
+	CategoryId int64 `json:"categoryId"`
+	MinSeats   int   `json:"minSeats"`
+}
+
+type TimeRange struct {
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+}
+
+type RoomAvailability struct {
+	Room          *Room        `json:"room"`
+	FreeIntervals []*TimeRange `json:"freeIntervals"`
+}
+
+type GetAvailabilityResp struct {
+	Rooms []*RoomAvailability `json:"rooms"`
 }
 
 type GetRoomsAndCategoriesResp struct {
@@ -72,9 +281,34 @@ type GetRoomsAndCategoriesResp struct {
 }
 
 type AddRoomReq struct {
-	Name       string `json:"name"`
-	Seats      int    `json:"seats"`
-	CategoryId int64  `json:"categoryId"`
+	Name             string `json:"name"`
+	Seats            int    `json:"seats"`
+	CategoryId       int64  `json:"categoryId"`
+	RequiresApproval bool   `json:"requiresApproval"`
+	Shared           bool   `json:"shared"`
+	RequiresCheckIn  bool   `json:"requiresCheckIn"`
+	BufferBefore     int64  `json:"bufferBefore"`
+	BufferAfter      int64  `json:"bufferAfter"`
+}
+
+type ScheduleGroupWithSchedules struct {
+	ScheduleGroup
+	Schedules []*Schedule `json:"schedules"`
+}
+
+type GetPendingScheduleGroupsResp struct {
+	Groups []*ScheduleGroupWithSchedules `json:"groups"`
+}
+
+type ApproveScheduleGroupReq struct {
+	ScheduleGroupId int64 `json:"scheduleGroupId"`
+	// optional note to the reservee
+	Reason string `json:"reason"`
+}
+
+type RejectScheduleGroupReq struct {
+	ScheduleGroupId int64  `json:"scheduleGroupId"`
+	Reason          string `json:"reason"`
 }
 
 type AddCategoryReq struct {
@@ -82,10 +316,364 @@ type AddCategoryReq struct {
 	Description string `json:"description"`
 }
 
+// UpdateRoomReq changes the fields which are not null.
+type UpdateRoomReq struct {
+	RoomId     int64   `json:"roomId"`
+	Name       *string `json:"name"`
+	Seats      *int    `json:"seats"`
+	CategoryId *int64  `json:"categoryId"`
+}
+
+// UpdateCategoryReq changes the fields which are not null.
+type UpdateCategoryReq struct {
+	CategoryId  int64   `json:"categoryId"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
 type DeleteRoomReq struct {
 	RoomId int64 `json:"roomId"`
+	// delete the room even if it has upcoming bookings
+	Force bool `json:"force"`
+}
+
+type DeleteRoomResp struct {
+	Msg string `json:"msg"`
+	// groups with upcoming bookings in the room, whose reservees are notified
+	// if the room is deleted
+	AffectedGroups []*ScheduleGroup `json:"affectedGroups"`
+}
+
+type ArchiveRoomReq struct {
+	RoomId   int64 `json:"roomId"`
+	Archived bool  `json:"archived"`
 }
 
 type DeleteCategoryReq struct {
 	CategoryId int64 `json:"categoryId"`
 }
+
+type GetBookingPoliciesResp struct {
+	Policies []*BookingPolicy `json:"policies"`
+}
+
+type AddBookingPolicyReq struct {
+	// -1 if policy of category
+	RoomId int64 `json:"roomId"`
+	// -1 if policy of room
+	CategoryId       int64 `json:"categoryId"`
+	MaxDuration      int64 `json:"maxDuration"`
+	MaxDaysInAdvance int   `json:"maxDaysInAdvance"`
+	MinNotice        int64 `json:"minNotice"`
+	SlotGranularity  int64 `json:"slotGranularity"`
+}
+
+type UpdateBookingPolicyReq struct {
+	PolicyId         int64 `json:"policyId"`
+	MaxDuration      int64 `json:"maxDuration"`
+	MaxDaysInAdvance int   `json:"maxDaysInAdvance"`
+	MinNotice        int64 `json:"minNotice"`
+	SlotGranularity  int64 `json:"slotGranularity"`
+}
+
+type DeleteBookingPolicyReq struct {
+	PolicyId int64 `json:"policyId"`
+}
+
+type GetOpeningHoursResp struct {
+	OpeningHours []*OpeningHours `json:"openingHours"`
+}
+
+type SetOpeningHoursReq struct {
+	RoomId int64 `json:"roomId"`
+	// replaces every opening hours of the room, empty for always open
+	OpeningHours []*OpeningHours `json:"openingHours"`
+}
+
+type GetClosuresResp struct {
+	Closures []*Closure `json:"closures"`
+}
+
+type AddClosureReq struct {
+	// -1 if closure of category
+	RoomId int64 `json:"roomId"`
+	// -1 if closure of room
+	CategoryId     int64  `json:"categoryId"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	RRule          string `json:"rrule"`
+	// server default if empty
+	Timezone string `json:"timezone"`
+	Reason   string `json:"reason"`
+}
+
+type DeleteClosureReq struct {
+	ClosureId int64 `json:"closureId"`
+}
+
+type GetBookingQuotasResp struct {
+	Quotas []*BookingQuota `json:"quotas"`
+}
+
+type AddBookingQuotaReq struct {
+	// -1 if quota of category
+	RoomId int64 `json:"roomId"`
+	// -1 if quota of room
+	CategoryId         int64 `json:"categoryId"`
+	MaxHoursPerWeek    int   `json:"maxHoursPerWeek"`
+	MaxFutureBookings  int   `json:"maxFutureBookings"`
+	MaxRecurringGroups int   `json:"maxRecurringGroups"`
+}
+
+type UpdateBookingQuotaReq struct {
+	QuotaId            int64 `json:"quotaId"`
+	MaxHoursPerWeek    int   `json:"maxHoursPerWeek"`
+	MaxFutureBookings  int   `json:"maxFutureBookings"`
+	MaxRecurringGroups int   `json:"maxRecurringGroups"`
+}
+
+type DeleteBookingQuotaReq struct {
+	QuotaId int64 `json:"quotaId"`
+}
+
+const (
+	WaitlistPromotionHold = "hold"
+	WaitlistPromotionBook = "book"
+)
+
+const (
+	WaitlistStatusWaiting = "waiting"
+	WaitlistStatusOffered = "offered"
+	WaitlistStatusBooked  = "booked"
+	WaitlistStatusExpired = "expired"
+)
+
+type WaitlistEntry struct {
+	Id             int64  `json:"id"`
+	RoomId         int64  `json:"roomId"`
+	UserIdx        int64  `json:"userIdx"`
+	Reservee       string `json:"reservee"`
+	Email          string `json:"email"`
+	PhoneNumber    string `json:"phoneNumber"`
+	Reason         string `json:"reason"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	Seats          int    `json:"seats"`
+	// one of WaitlistPromotion*
+	Promotion string `json:"promotion"`
+	// one of WaitlistStatus*
+	Status string `json:"status"`
+	// group created by promotion, -1 if not promoted yet
+	ScheduleGroupId  int64 `json:"scheduleGroupId"`
+	CreatedTimestamp int64 `json:"createdTimestamp"`
+}
+
+type JoinWaitlistReq struct {
+	RoomId         int64  `json:"roomId"`
+	Reservee       string `json:"reservee"`
+	Email          string `json:"email"`
+	PhoneNumber    string `json:"phoneNumber"`
+	Reason         string `json:"reason"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	// seats to book in a shared room, a seat if 0
+	Seats int `json:"seats"`
+	// one of WaitlistPromotion*, hold if empty
+	Promotion string `json:"promotion"`
+}
+
+type GetMyWaitlistEntriesResp struct {
+	Entries []*WaitlistEntry `json:"entries"`
+}
+
+type LeaveWaitlistReq struct {
+	EntryId int64 `json:"entryId"`
+}
+
+type ConfirmWaitlistEntryReq struct {
+	EntryId int64 `json:"entryId"`
+}
+
+type HoldScheduleReq struct {
+	RoomId         int64 `json:"roomId"`
+	StartTimestamp int64 `json:"startTimestamp"`
+	EndTimestamp   int64 `json:"endTimestamp"`
+	// seats to hold in a shared room, a seat if 0
+	Seats int `json:"seats"`
+	// specific seat to hold in a shared room, any seat if 0
+	Seat int `json:"seat"`
+}
+
+type HoldScheduleResp struct {
+	ScheduleGroupId int64 `json:"scheduleGroupId"`
+	ScheduleId      int64 `json:"scheduleId"`
+	ExpiresAt       int64 `json:"expiresAt"`
+}
+
+type ConfirmHoldReq struct {
+	ScheduleGroupId int64  `json:"scheduleGroupId"`
+	Reservee        string `json:"reservee"`
+	Email           string `json:"email"`
+	PhoneNumber     string `json:"phoneNumber"`
+	Reason          string `json:"reason"`
+}
+
+type ReleaseHoldReq struct {
+	ScheduleGroupId int64 `json:"scheduleGroupId"`
+}
+
+type CheckInReq struct {
+	ScheduleId int64 `json:"scheduleId"`
+	// token of a kiosk of the room, the owner of the schedule checks in if empty
+	KioskToken string `json:"kioskToken"`
+}
+
+type IssueKioskTokenReq struct {
+	RoomId int64 `json:"roomId"`
+}
+
+type IssueKioskTokenResp struct {
+	Token string `json:"token"`
+}
+
+type UserNoShows struct {
+	UserIdx     int64 `json:"userIdx"`
+	NoShowCount int   `json:"noShowCount"`
+}
+
+type GetNoShowsResp struct {
+	Users []*UserNoShows `json:"users"`
+}
+
+const (
+	RoleViewer      = "viewer"
+	RoleBooker      = "booker"
+	RoleRoomManager = "room_manager"
+	RoleAdmin       = "admin"
+)
+
+// RoleGrant grants a role to a user in a room, in every room of a category,
+// or globally if both RoomId and CategoryId are -1.
+type RoleGrant struct {
+	Id      int64  `json:"id"`
+	UserIdx int64  `json:"userIdx"`
+	Role    string `json:"role"`
+	// -1 if not granted in a single room
+	RoomId int64 `json:"roomId"`
+	// -1 if not granted in a category
+	CategoryId int64 `json:"categoryId"`
+}
+
+type GetRoleGrantsResp struct {
+	Grants []*RoleGrant `json:"grants"`
+}
+
+type AddRoleGrantReq struct {
+	UserIdx    int64  `json:"userIdx"`
+	Role       string `json:"role"`
+	RoomId     int64  `json:"roomId"`
+	CategoryId int64  `json:"categoryId"`
+}
+
+type DeleteRoleGrantReq struct {
+	GrantId int64 `json:"grantId"`
+}
+
+// RoomManagerReq names a manager of a room, or of every room of a category.
+// Exactly one of RoomId and CategoryId is -1.
+type RoomManagerReq struct {
+	UserIdx    int64 `json:"userIdx"`
+	RoomId     int64 `json:"roomId"`
+	CategoryId int64 `json:"categoryId"`
+}
+
+type GetRoomManagersResp struct {
+	Managers []*RoleGrant `json:"managers"`
+}
+
+const (
+	// api tokens which can only read
+	APITokenScopeRead = "read"
+	// api tokens which can read and book, but not manage
+	APITokenScopeBook = "book"
+	// api tokens which can do whatever their owner can
+	APITokenScopeAdmin = "admin"
+)
+
+// APIToken is a personal token for scripts to call the api with instead of a
+// jwt. The token itself is only shown when it is issued.
+type APIToken struct {
+	Id               int64  `json:"id"`
+	UserIdx          int64  `json:"userIdx"`
+	Username         string `json:"username"`
+	Name             string `json:"name"`
+	Scope            string `json:"scope"`
+	CreatedTimestamp int64  `json:"createdTimestamp"`
+	ExpireTimestamp  int64  `json:"expireTimestamp"`
+	// -1 if never used
+	LastUsedTimestamp int64 `json:"lastUsedTimestamp"`
+	Revoked           bool  `json:"revoked"`
+}
+
+type GetAPITokensResp struct {
+	Tokens []*APIToken `json:"tokens"`
+}
+
+type IssueAPITokenReq struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// seconds until the token expires
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+type IssueAPITokenResp struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type RevokeAPITokenReq struct {
+	TokenId int64 `json:"tokenId"`
+}
+
+// AuditActor is who a change is recorded as made by in the audit log.
+type AuditActor struct {
+	UserIdx   int64
+	Username  string
+	RequestId string
+}
+
+// AuditLogEntry is a change of a row of schedules, schedule_groups, rooms or
+// categories. Before and After are the row as stored, null when it was added
+// or deleted respectively.
+type AuditLogEntry struct {
+	Id        int64 `json:"id"`
+	Timestamp int64 `json:"timestamp"`
+	// -1 if the change was made by the server itself
+	UserIdx  int64  `json:"userIdx"`
+	Username string `json:"username"`
+	// one of "add", "update" and "delete"
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityId  int64           `json:"entityId"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	RequestId string          `json:"requestId"`
+}
+
+// GetAuditLogReq filters the audit log. Fields which are -1 or empty match
+// every entry.
+type GetAuditLogReq struct {
+	UserIdx        int64  `json:"userIdx"`
+	Action         string `json:"action"`
+	Entity         string `json:"entity"`
+	EntityId       int64  `json:"entityId"`
+	RequestId      string `json:"requestId"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	Page           int    `json:"page"`
+	PageSize       int    `json:"pageSize"`
+}
+
+type GetAuditLogResp struct {
+	Entries []*AuditLogEntry `json:"entries"`
+}